@@ -6,6 +6,7 @@ import (
 	"github.com/darwin-containers/rund/containerd"
 )
 
+// withoutReaper disables containerd reaper, rund reaps its children itself
 func withoutReaper(config *shim.Config) {
 	config.NoReaper = true
 }
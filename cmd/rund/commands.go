@@ -0,0 +1,218 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+
+	taskAPI "github.com/containerd/containerd/api/runtime/task/v3"
+	"github.com/containerd/containerd/api/types/task"
+	"github.com/containerd/containerd/v2/pkg/oci"
+	"github.com/containerd/errdefs"
+	"github.com/containerd/ttrpc"
+	"github.com/darwin-containers/rund/containerd"
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"golang.org/x/sys/unix"
+)
+
+func start(ctx context.Context, g *globalOptions, args []string) error {
+	args, err := parseCommand(newFlagSet("start"), args, 1)
+	if err != nil {
+		return err
+	}
+	id := args[0]
+
+	client, err := connect(g, id)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	started, err := client.Start(ctx, &taskAPI.StartRequest{ID: id})
+	if err != nil {
+		return fromMonitor(err)
+	}
+
+	// Container processes are forked on start, so the pid file requested by create is written only now
+	dir, err := g.containerDir(id)
+	if err != nil {
+		return err
+	}
+
+	pidFile, err := os.ReadFile(filepath.Join(dir, pidFileName))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	return writePidFile(string(pidFile), started.Pid)
+}
+
+func writePidFile(path string, pid uint32) error {
+	return os.WriteFile(path, []byte(strconv.FormatUint(uint64(pid), 10)), 0o644)
+}
+
+// ociStatus maps task statuses to OCI container statuses.
+var ociStatus = map[task.Status]specs.ContainerState{
+	task.Status_CREATED: specs.StateCreated,
+	task.Status_RUNNING: specs.StateRunning,
+	task.Status_STOPPED: specs.StateStopped,
+	task.Status_PAUSED:  "paused",
+}
+
+func state(ctx context.Context, g *globalOptions, args []string) error {
+	args, err := parseCommand(newFlagSet("state"), args, 1)
+	if err != nil {
+		return err
+	}
+	id := args[0]
+
+	client, err := connect(g, id)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	st, err := client.State(ctx, &taskAPI.StateRequest{ID: id})
+	if err != nil {
+		return fromMonitor(err)
+	}
+
+	spec, err := oci.ReadSpec(filepath.Join(st.Bundle, oci.ConfigFilename))
+	if err != nil {
+		return err
+	}
+
+	status, ok := ociStatus[st.Status]
+	if !ok {
+		status = specs.ContainerState(strings.ToLower(st.Status.String()))
+	}
+
+	return printJSON(&specs.State{
+		Version:     specs.Version,
+		ID:          id,
+		Status:      status,
+		Pid:         int(st.Pid),
+		Bundle:      st.Bundle,
+		Annotations: spec.Annotations,
+	})
+}
+
+func kill(ctx context.Context, g *globalOptions, args []string) error {
+	fs := newFlagSet("kill")
+	all := fs.Bool("all", false, "send the signal to all processes of the container")
+
+	args, err := parseCommand(fs, args, 1)
+	if err != nil {
+		return err
+	}
+	id := args[0]
+
+	signal := syscall.SIGTERM
+	if len(args) > 1 {
+		if signal, err = parseSignal(args[1]); err != nil {
+			return err
+		}
+	}
+
+	client, err := connect(g, id)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	_, err = client.Kill(ctx, &taskAPI.KillRequest{ID: id, Signal: uint32(signal), All: *all})
+	return fromMonitor(err)
+}
+
+// parseSignal parses signal number or name, with or without SIG prefix.
+func parseSignal(s string) (syscall.Signal, error) {
+	if n, err := strconv.Atoi(s); err == nil {
+		return syscall.Signal(n), nil
+	}
+
+	name := strings.ToUpper(s)
+	if !strings.HasPrefix(name, "SIG") {
+		name = "SIG" + name
+	}
+
+	if signal := unix.SignalNum(name); signal != 0 {
+		return signal, nil
+	}
+
+	return 0, fmt.Errorf("unknown signal %q: %w", s, errdefs.ErrInvalidArgument)
+}
+
+func deleteContainer(ctx context.Context, g *globalOptions, args []string) error {
+	fs := newFlagSet("delete")
+	force := fs.Bool("force", false, "kill the container if it is running, remove the state of containers whose monitor is gone")
+
+	args, err := parseCommand(fs, args, 1)
+	if err != nil {
+		return err
+	}
+	id := args[0]
+
+	dir, err := g.containerDir(id)
+	if err != nil {
+		return err
+	}
+
+	client, err := connect(g, id)
+	if err != nil {
+		if *force && !errdefs.IsNotFound(err) {
+			return os.RemoveAll(dir)
+		}
+		return err
+	}
+	defer client.Close()
+
+	if *force {
+		st, err := client.State(ctx, &taskAPI.StateRequest{ID: id})
+		if err != nil {
+			return fromMonitor(err)
+		}
+
+		if st.Status == task.Status_RUNNING || st.Status == task.Status_PAUSED {
+			if _, err := client.Kill(ctx, &taskAPI.KillRequest{ID: id, Signal: uint32(syscall.SIGKILL), All: true}); err != nil {
+				return fromMonitor(err)
+			}
+			if _, err := client.Wait(ctx, &taskAPI.WaitRequest{ID: id}); err != nil {
+				return fromMonitor(err)
+			}
+		}
+	}
+
+	if _, err := client.Delete(ctx, &taskAPI.DeleteRequest{ID: id}); err != nil {
+		return fromMonitor(err)
+	}
+
+	// The monitor exits once it has no containers left
+	if _, err := client.Shutdown(ctx, &taskAPI.ShutdownRequest{ID: id}); err != nil && !errors.Is(err, ttrpc.ErrClosed) {
+		return fromMonitor(err)
+	}
+
+	return os.RemoveAll(dir)
+}
+
+func printFeatures(_ context.Context, _ *globalOptions, args []string) error {
+	if _, err := parseCommand(newFlagSet("features"), args, 0); err != nil {
+		return err
+	}
+
+	return printJSON(containerd.RuntimeFeatures())
+}
+
+func printJSON(v any) error {
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(v)
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	taskAPI "github.com/containerd/containerd/api/runtime/task/v3"
+	"github.com/containerd/containerd/v2/core/events"
+	"github.com/containerd/containerd/v2/pkg/shutdown"
+	"github.com/containerd/errdefs"
+	"github.com/containerd/log"
+	"github.com/containerd/ttrpc"
+	"github.com/darwin-containers/rund/containerd"
+)
+
+const (
+	// monitorTimeout is how long create waits for the monitor to start serving
+	monitorTimeout = 10 * time.Second
+	// outputTimeout is how long the monitor waits for outputs of the container to be copied once it is shut down
+	outputTimeout = time.Second
+)
+
+func create(ctx context.Context, g *globalOptions, args []string) (retErr error) {
+	fs := newFlagSet("create")
+	bundle := fs.String("bundle", ".", "path to the bundle directory")
+	pidFile := fs.String("pid-file", "", "file to write the pid of the container process to once it starts")
+	consoleSocket := fs.String("console-socket", "", "not supported, rund allocates terminals itself and copies them to its stdio")
+
+	args, err := parseCommand(fs, args, 1)
+	if err != nil {
+		return err
+	}
+	id := args[0]
+
+	if *consoleSocket != "" {
+		return fmt.Errorf("--console-socket: %w", errdefs.ErrNotImplemented)
+	}
+
+	dir, err := g.containerDir(id)
+	if err != nil {
+		return err
+	}
+
+	bundlePath, err := filepath.Abs(*bundle)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(g.root, 0o711); err != nil {
+		return err
+	}
+
+	if err := os.Mkdir(dir, 0o711); err != nil {
+		if os.IsExist(err) {
+			return fmt.Errorf("container %s already exists: %w", id, errdefs.ErrAlreadyExists)
+		}
+		return err
+	}
+
+	defer func() {
+		if retErr != nil {
+			_ = os.RemoveAll(dir)
+		}
+	}()
+
+	if *pidFile != "" {
+		path, err := filepath.Abs(*pidFile)
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(filepath.Join(dir, pidFileName), []byte(path), 0o600); err != nil {
+			return err
+		}
+	}
+
+	self, err := os.Executable()
+	if err != nil {
+		return err
+	}
+
+	// The monitor inherits stdio of create, container processes write into it through fifos copied by the monitor.
+	// It runs in the bundle, so mDNSResponder socket paths of the task service stay short.
+	cmd := exec.Command(self, append(g.args(), "monitor", id)...)
+	cmd.Dir = bundlePath
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Setsid: true,
+	}
+
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to start monitor: %w", err)
+	}
+
+	exited := make(chan error, 1)
+	go func() {
+		exited <- cmd.Wait()
+	}()
+
+	client, err := waitMonitor(g, id, exited)
+	if err != nil {
+		_ = cmd.Process.Kill()
+		return err
+	}
+	defer client.Close()
+
+	_, err = client.Create(ctx, &taskAPI.CreateTaskRequest{
+		ID:     id,
+		Bundle: bundlePath,
+		Stdin:  filepath.Join(dir, "stdin"),
+		Stdout: filepath.Join(dir, "stdout"),
+		Stderr: filepath.Join(dir, "stderr"),
+	})
+	if err != nil {
+		_, _ = client.Shutdown(ctx, &taskAPI.ShutdownRequest{ID: id})
+		return fromMonitor(err)
+	}
+
+	return nil
+}
+
+// waitMonitor connects to the monitor of container id once it serves, or fails if the monitor exits first.
+func waitMonitor(g *globalOptions, id string, exited <-chan error) (*monitorClient, error) {
+	deadline := time.After(monitorTimeout)
+	for {
+		client, err := connect(g, id)
+		if err == nil {
+			return client, nil
+		}
+
+		select {
+		case err := <-exited:
+			return nil, fmt.Errorf("monitor exited: %w", err)
+		case <-deadline:
+			return nil, fmt.Errorf("timed out waiting for monitor: %w", err)
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
+
+type discardPublisher struct{}
+
+func (discardPublisher) Publish(context.Context, string, events.Event) error {
+	return nil
+}
+
+func (discardPublisher) Close() error {
+	return nil
+}
+
+// monitor runs the task service for container id until it is shut down by delete.
+func monitor(ctx context.Context, g *globalOptions, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("monitor requires container id: %w", errdefs.ErrInvalidArgument)
+	}
+	id := args[0]
+
+	dir, err := g.containerDir(id)
+	if err != nil {
+		return err
+	}
+
+	logPath := g.log
+	if logPath == "" {
+		logPath = filepath.Join(dir, "log")
+	}
+
+	logFile, err := os.OpenFile(logPath, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		return err
+	}
+	defer logFile.Close()
+
+	log.L.Logger.SetOutput(logFile)
+	if g.debug {
+		if err := log.SetLevel("debug"); err != nil {
+			return err
+		}
+	}
+	ctx = log.WithLogger(ctx, log.G(ctx).WithField("id", id))
+
+	ctx, sd := shutdown.WithShutdown(ctx)
+	defer sd.Shutdown()
+
+	ts, err := containerd.NewTaskService(ctx, discardPublisher{}, sd)
+	if err != nil {
+		return err
+	}
+
+	stdio, err := newFifoIO(ctx, dir, os.Stdin, os.Stdout, os.Stderr)
+	if err != nil {
+		return err
+	}
+
+	server, err := ttrpc.NewServer()
+	if err != nil {
+		return err
+	}
+	taskAPI.RegisterTTRPCTaskService(server, ts)
+
+	socketPath := filepath.Join(dir, socketName)
+	l, err := net.Listen("unix", socketPath)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(socketPath)
+
+	go func() {
+		if err := server.Serve(ctx, l); err != nil && !errors.Is(err, ttrpc.ErrServerClosed) {
+			log.G(ctx).WithError(err).Error("failed to serve task service")
+			sd.Shutdown()
+		}
+	}()
+
+	<-ctx.Done()
+
+	// Shutdown waits for the response of the Shutdown request
+	shutdownCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), outputTimeout)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		log.G(ctx).WithError(err).Warn("failed to shut down task service")
+	}
+
+	done := make(chan struct{})
+	go func() {
+		stdio.wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(outputTimeout):
+		log.G(ctx).Warn("timed out copying container output")
+	}
+
+	return nil
+}
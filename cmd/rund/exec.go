@@ -0,0 +1,259 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/containerd/console"
+	taskAPI "github.com/containerd/containerd/api/runtime/task/v3"
+	"github.com/containerd/containerd/v2/pkg/oci"
+	"github.com/containerd/errdefs"
+	"github.com/containerd/log"
+	"github.com/containerd/typeurl/v2"
+	"github.com/opencontainers/runtime-spec/specs-go"
+)
+
+// stringsFlag is a flag that can be repeated.
+type stringsFlag []string
+
+func (f *stringsFlag) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *stringsFlag) Set(value string) error {
+	*f = append(*f, value)
+	return nil
+}
+
+var _ flag.Value = (*stringsFlag)(nil)
+
+func execProcess(ctx context.Context, g *globalOptions, args []string) (retErr error) {
+	fs := newFlagSet("exec")
+	processPath := fs.String("process", "", "path to process.json, replaces args and other flags")
+	detach := fs.Bool("detach", false, "do not wait for the process, its stdio is discarded")
+	tty := fs.Bool("tty", false, "allocate a terminal")
+	pidFile := fs.String("pid-file", "", "file to write the pid of the process to")
+	cwd := fs.String("cwd", "", "working directory of the process")
+	var env stringsFlag
+	fs.Var(&env, "env", "environment variable of the process")
+
+	args, err := parseCommand(fs, args, 1)
+	if err != nil {
+		return err
+	}
+	id := args[0]
+
+	client, err := connect(g, id)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	var process *specs.Process
+	if *processPath != "" {
+		data, err := os.ReadFile(*processPath)
+		if err != nil {
+			return err
+		}
+		if err := json.Unmarshal(data, &process); err != nil {
+			return fmt.Errorf("failed to parse %s: %w", *processPath, err)
+		}
+	} else {
+		if len(args) < 2 {
+			return fmt.Errorf("exec requires either --process or args: %w", errdefs.ErrInvalidArgument)
+		}
+
+		if process, err = containerProcess(ctx, client, id); err != nil {
+			return err
+		}
+
+		process.Args = args[1:]
+		process.Terminal = *tty
+		process.Env = append(process.Env, env...)
+		if *cwd != "" {
+			process.Cwd = *cwd
+		}
+	}
+
+	spec, err := typeurl.MarshalAnyToProto(process)
+	if err != nil {
+		return err
+	}
+
+	execID, err := newExecID()
+	if err != nil {
+		return err
+	}
+
+	request := &taskAPI.ExecProcessRequest{
+		ID:       id,
+		ExecID:   execID,
+		Spec:     spec,
+		Terminal: process.Terminal,
+	}
+
+	// Stdio is copied only while rund waits for the process
+	var stdio *fifoIO
+	if !*detach {
+		dir, err := g.containerDir(id)
+		if err != nil {
+			return err
+		}
+
+		ioDir, err := os.MkdirTemp(dir, execID)
+		if err != nil {
+			return err
+		}
+		defer os.RemoveAll(ioDir)
+
+		ioCtx, cancel := context.WithCancel(ctx)
+		defer cancel()
+
+		if stdio, err = newFifoIO(ioCtx, ioDir, os.Stdin, os.Stdout, os.Stderr); err != nil {
+			return err
+		}
+
+		request.Stdin = stdio.stdin
+		request.Stdout = stdio.stdout
+		request.Stderr = stdio.stderr
+	}
+
+	if _, err := client.Exec(ctx, request); err != nil {
+		return fromMonitor(err)
+	}
+
+	if !*detach {
+		defer func() {
+			if _, err := client.Delete(ctx, &taskAPI.DeleteRequest{ID: id, ExecID: execID}); err != nil && retErr == nil {
+				retErr = fromMonitor(err)
+			}
+		}()
+	}
+
+	var current console.Console
+	if process.Terminal && !*detach {
+		if current, err = console.ConsoleFromFile(os.Stdin); err == nil {
+			if err := current.SetRaw(); err != nil {
+				return err
+			}
+			defer current.Reset()
+		}
+	}
+
+	started, err := client.Start(ctx, &taskAPI.StartRequest{ID: id, ExecID: execID})
+	if err != nil {
+		return fromMonitor(err)
+	}
+
+	if *pidFile != "" {
+		if err := writePidFile(*pidFile, started.Pid); err != nil {
+			return err
+		}
+	}
+
+	if *detach {
+		return nil
+	}
+
+	forwardCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	go forwardSignals(forwardCtx, client, id, execID)
+	if current != nil {
+		go resizeTerminal(forwardCtx, client, id, execID, current)
+	}
+
+	waited, err := client.Wait(ctx, &taskAPI.WaitRequest{ID: id, ExecID: execID})
+	if err != nil {
+		return fromMonitor(err)
+	}
+
+	stdio.wait()
+
+	if waited.ExitStatus != 0 {
+		return &exitError{status: int(waited.ExitStatus)}
+	}
+	return nil
+}
+
+// containerProcess returns the process of the container spec, that exec processes inherit user, environment and cwd from.
+func containerProcess(ctx context.Context, client *monitorClient, id string) (*specs.Process, error) {
+	st, err := client.State(ctx, &taskAPI.StateRequest{ID: id})
+	if err != nil {
+		return nil, fromMonitor(err)
+	}
+
+	spec, err := oci.ReadSpec(filepath.Join(st.Bundle, oci.ConfigFilename))
+	if err != nil {
+		return nil, err
+	}
+
+	if spec.Process == nil {
+		return &specs.Process{}, nil
+	}
+
+	process := *spec.Process
+	process.Env = append([]string(nil), spec.Process.Env...)
+	process.ConsoleSize = nil
+
+	return &process, nil
+}
+
+func newExecID() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return "exec-" + hex.EncodeToString(b), nil
+}
+
+// forwardSignals forwards signals received by rund to the exec process.
+func forwardSignals(ctx context.Context, client *monitorClient, id, execID string) {
+	signals := make(chan os.Signal, 16)
+	signal.Notify(signals, syscall.SIGHUP, syscall.SIGINT, syscall.SIGQUIT, syscall.SIGTERM, syscall.SIGUSR1, syscall.SIGUSR2)
+	defer signal.Stop(signals)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case s := <-signals:
+			request := &taskAPI.KillRequest{ID: id, ExecID: execID, Signal: uint32(s.(syscall.Signal))}
+			if _, err := client.Kill(ctx, request); err != nil {
+				log.G(ctx).WithError(err).Warnf("failed to forward %s", s)
+			}
+		}
+	}
+}
+
+// resizeTerminal keeps the size of the exec process terminal in sync with the terminal of rund.
+func resizeTerminal(ctx context.Context, client *monitorClient, id, execID string, current console.Console) {
+	winch := make(chan os.Signal, 1)
+	signal.Notify(winch, syscall.SIGWINCH)
+	defer signal.Stop(winch)
+
+	for {
+		if size, err := current.Size(); err == nil {
+			request := &taskAPI.ResizePtyRequest{ID: id, ExecID: execID, Width: uint32(size.Width), Height: uint32(size.Height)}
+			if _, err := client.ResizePty(ctx, request); err != nil {
+				log.G(ctx).WithError(err).Debug("failed to resize terminal")
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-winch:
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+
+	"github.com/containerd/fifo"
+	"github.com/containerd/log"
+	"golang.org/x/sys/unix"
+)
+
+// fifoIO connects stdio of a container process to streams of rund through fifos.
+// The task service opens the other ends of the fifos, the way it opens fifos created by containerd.
+type fifoIO struct {
+	stdin, stdout, stderr string
+
+	// outputs waits for stdout and stderr to be copied until the process closes them
+	outputs sync.WaitGroup
+}
+
+// newFifoIO creates fifos in dir and starts copying stdin into a fifo, and fifos into stdout and stderr.
+// Fifos are opened once the task service opens their other ends, copying is abandoned when ctx is done before that.
+func newFifoIO(ctx context.Context, dir string, stdin io.Reader, stdout, stderr io.Writer) (*fifoIO, error) {
+	f := &fifoIO{
+		stdin:  filepath.Join(dir, "stdin"),
+		stdout: filepath.Join(dir, "stdout"),
+		stderr: filepath.Join(dir, "stderr"),
+	}
+
+	for _, path := range []string{f.stdin, f.stdout, f.stderr} {
+		if err := unix.Mkfifo(path, 0o600); err != nil {
+			return nil, &os.PathError{Op: "mkfifo", Path: path, Err: err}
+		}
+	}
+
+	// Stdin is closed once stdin of rund is exhausted, so the process gets EOF
+	go func() {
+		w, err := fifo.OpenFifo(ctx, f.stdin, syscall.O_WRONLY, 0)
+		if err != nil {
+			return
+		}
+		defer w.Close()
+
+		if _, err := io.Copy(w, stdin); err != nil {
+			log.G(ctx).WithError(err).Debug("failed to copy stdin")
+		}
+	}()
+
+	for path, w := range map[string]io.Writer{f.stdout: stdout, f.stderr: stderr} {
+		f.outputs.Add(1)
+		go func() {
+			defer f.outputs.Done()
+
+			r, err := fifo.OpenFifo(ctx, path, syscall.O_RDONLY, 0)
+			if err != nil {
+				return
+			}
+			defer r.Close()
+
+			if _, err := io.Copy(w, r); err != nil {
+				log.G(ctx).WithError(err).Debugf("failed to copy %s", filepath.Base(path))
+			}
+		}()
+	}
+
+	return f, nil
+}
+
+// wait waits until outputs of the process are copied.
+func (f *fifoIO) wait() {
+	f.outputs.Wait()
+}
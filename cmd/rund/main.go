@@ -0,0 +1,211 @@
+// Command rund implements the OCI runtime command line interface on top of the rund task service.
+//
+// Every container is run by its own monitor process, a detached rund that serves the task service
+// on a socket in the state directory of the container. Other commands talk to the monitor over ttrpc,
+// so containers created this way behave exactly like containers created by containerd.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"maps"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+
+	taskAPI "github.com/containerd/containerd/api/runtime/task/v3"
+	// Registers OCI spec types for typeurl
+	_ "github.com/containerd/containerd/v2/core/runtime"
+	"github.com/containerd/errdefs"
+	"github.com/containerd/errdefs/pkg/errgrpc"
+	"github.com/containerd/ttrpc"
+)
+
+const (
+	defaultRoot = "/var/run/rund"
+
+	// socketName is the name of the monitor socket in the state directory of a container
+	socketName = "rund.sock"
+	// pidFileName stores the path of the pid file requested by create, it is written once the container starts
+	pidFileName = "pid-file"
+)
+
+var validID = regexp.MustCompile(`^[\w+\-.]+$`)
+
+// globalOptions are flags shared by all commands, they are passed on to the monitor.
+type globalOptions struct {
+	root  string
+	log   string
+	debug bool
+}
+
+func (g *globalOptions) containerDir(id string) (string, error) {
+	if !validID.MatchString(id) || id == "." || id == ".." {
+		return "", fmt.Errorf("invalid container id %q: %w", id, errdefs.ErrInvalidArgument)
+	}
+
+	return filepath.Join(g.root, id), nil
+}
+
+func (g *globalOptions) args() []string {
+	args := []string{"--root", g.root}
+	if g.log != "" {
+		args = append(args, "--log", g.log)
+	}
+	if g.debug {
+		args = append(args, "--debug")
+	}
+	return args
+}
+
+type command struct {
+	usage string
+	run   func(ctx context.Context, g *globalOptions, args []string) error
+	// hidden commands are internal to rund and not listed in usage
+	hidden bool
+}
+
+// commands are set up in init, since their functions refer to the map for usage
+var commands map[string]command
+
+func init() {
+	commands = map[string]command{
+		"create":   {usage: "create [--bundle <path>] [--pid-file <path>] <id>", run: create},
+		"start":    {usage: "start <id>", run: start},
+		"state":    {usage: "state <id>", run: state},
+		"kill":     {usage: "kill [--all] <id> [<signal>]", run: kill},
+		"delete":   {usage: "delete [--force] <id>", run: deleteContainer},
+		"exec":     {usage: "exec [--process <path>] [--detach] [--tty] [--pid-file <path>] [--cwd <path>] [--env <key=value>]... <id> [<arg>...]", run: execProcess},
+		"features": {usage: "features", run: printFeatures},
+		"monitor":  {run: monitor, hidden: true},
+	}
+}
+
+// exitError makes rund exit with the status of an exec process without printing an error.
+type exitError struct {
+	status int
+}
+
+func (e *exitError) Error() string {
+	return fmt.Sprintf("exit status %d", e.status)
+}
+
+func main() {
+	err := run(context.Background(), os.Args[1:])
+
+	var exit *exitError
+	switch {
+	case errors.As(err, &exit):
+		os.Exit(exit.status)
+	case errors.Is(err, flag.ErrHelp):
+		os.Exit(2)
+	case err != nil:
+		fmt.Fprintf(os.Stderr, "rund: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func run(ctx context.Context, args []string) error {
+	g := &globalOptions{}
+
+	fs := flag.NewFlagSet("rund", flag.ContinueOnError)
+	fs.StringVar(&g.root, "root", defaultRoot, "root directory for container state")
+	fs.StringVar(&g.log, "log", "", "log file of container monitors, defaults to log in the container state directory")
+	fs.BoolVar(&g.debug, "debug", false, "enable debug logging")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: rund [<flags>] <command> [<args>]\n\nCommands:\n")
+		for _, name := range slices.Sorted(maps.Keys(commands)) {
+			if c := commands[name]; !c.hidden {
+				fmt.Fprintf(fs.Output(), "  %s\n", c.usage)
+			}
+		}
+		fmt.Fprintf(fs.Output(), "\nFlags:\n")
+		fs.PrintDefaults()
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	c, ok := commands[fs.Arg(0)]
+	if !ok {
+		fs.Usage()
+		return flag.ErrHelp
+	}
+
+	root, err := filepath.Abs(g.root)
+	if err != nil {
+		return err
+	}
+	g.root = root
+
+	return c.run(ctx, g, fs.Args()[1:])
+}
+
+// parseCommand parses flags of a command and checks that at least minArgs positional arguments remain.
+func parseCommand(fs *flag.FlagSet, args []string, minArgs int) ([]string, error) {
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	if fs.NArg() < minArgs {
+		fs.Usage()
+		return nil, flag.ErrHelp
+	}
+
+	return fs.Args(), nil
+}
+
+func newFlagSet(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: rund %s\n", commands[name].usage)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// monitorClient is a connection to the monitor of a container.
+type monitorClient struct {
+	taskAPI.TTRPCTaskService
+	client *ttrpc.Client
+}
+
+func (c *monitorClient) Close() error {
+	return c.client.Close()
+}
+
+// connect connects to the monitor of container id.
+func connect(g *globalOptions, id string) (*monitorClient, error) {
+	dir, err := g.containerDir(id)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		return nil, fmt.Errorf("container %s does not exist: %w", id, errdefs.ErrNotFound)
+	}
+
+	conn, err := net.Dial("unix", filepath.Join(dir, socketName))
+	if err != nil {
+		return nil, fmt.Errorf("monitor of container %s is not running: %w", id, err)
+	}
+
+	client := ttrpc.NewClient(conn)
+	return &monitorClient{
+		TTRPCTaskService: taskAPI.NewTTRPCTaskClient(client),
+		client:           client,
+	}, nil
+}
+
+// fromMonitor converts an error returned by the monitor to a native error.
+func fromMonitor(err error) error {
+	if err == nil {
+		return nil
+	}
+	return errgrpc.ToNative(err)
+}
@@ -0,0 +1,189 @@
+package containerd
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/containerd/errdefs"
+	"github.com/containerd/errdefs/pkg/errgrpc"
+)
+
+// Annotations of config.json that configure a container.
+// BuildKit and Docker pass per-container settings only as annotations, so every container setting of rund is one.
+const (
+	// annotationPrefix prefixes annotations of rund, annotations with the prefix that rund doesn't know are rejected
+	annotationPrefix = "io.rund."
+
+	// socketsAnnotation forwards host unix sockets into the container, as comma separated container-path=host-path pairs
+	socketsAnnotation = annotationPrefix + "sockets"
+	// stopTimeoutAnnotation is how long processes that still run when the container is deleted
+	// have to exit after SIGTERM before they are killed, as Go duration. They are killed right away by default.
+	stopTimeoutAnnotation = annotationPrefix + "stop-timeout"
+	// memoryLimitAnnotation limits memory of every process that rund starts in the container, in bytes.
+	// It is the address space limit on Linux and the jetsam limit of memory footprint on Darwin.
+	memoryLimitAnnotation = annotationPrefix + "memory-limit"
+	// pidsLimitAnnotation limits the number of processes of the container user
+	pidsLimitAnnotation = annotationPrefix + "pids-limit"
+	// defaultArgsAnnotation sets args of processes whose spec sets none, as JSON array, see Options.DefaultArgs
+	defaultArgsAnnotation = annotationPrefix + "default-args"
+	// logFileAnnotation enables mirroring log entries of the container to logFileName in its bundle
+	logFileAnnotation = annotationPrefix + "log-file"
+	// execInheritEnvAnnotation makes exec processes inherit environment of the primary process,
+	// variables of the exec spec take precedence
+	execInheritEnvAnnotation = annotationPrefix + "exec-inherit-env"
+	// validationAnnotation selects how specs with fields that rund doesn't support are handled, see validationMode
+	validationAnnotation = annotationPrefix + "validation"
+)
+
+// containerConfig is the configuration of a container set with annotations.
+type containerConfig struct {
+	sockets     []socketForward
+	stopTimeout time.Duration
+	limits      processLimits
+	// defaultArgs are args of processes without args, nil if annotations don't set them
+	defaultArgs []string
+	logFile     bool
+	// execInheritEnv tells whether exec processes inherit environment of the primary process
+	execInheritEnv bool
+	// validation is the validation mode requested by annotations, empty if there is none
+	validation validationMode
+}
+
+// socketForward forwards connections to a socket in the container to a host socket.
+type socketForward struct {
+	// path of the socket in the container
+	path string
+	// hostPath of the socket that connections are forwarded to
+	hostPath string
+}
+
+// annotationParsers parse values of rund annotations into containerConfig
+var annotationParsers = map[string]func(config *containerConfig, value string) error{
+	socketsAnnotation: func(config *containerConfig, value string) (err error) {
+		config.sockets, err = parseSocketForwards(value)
+		return err
+	},
+	stopTimeoutAnnotation: func(config *containerConfig, value string) (err error) {
+		config.stopTimeout, err = time.ParseDuration(value)
+		if err == nil && config.stopTimeout < 0 {
+			err = fmt.Errorf("must not be negative")
+		}
+		return err
+	},
+	memoryLimitAnnotation: func(config *containerConfig, value string) (err error) {
+		config.limits.memory, err = parseLimit(value)
+		return err
+	},
+	pidsLimitAnnotation: func(config *containerConfig, value string) (err error) {
+		config.limits.pids, err = parseLimit(value)
+		return err
+	},
+	defaultArgsAnnotation: func(config *containerConfig, value string) error {
+		if err := json.Unmarshal([]byte(value), &config.defaultArgs); err != nil {
+			return err
+		}
+		if len(config.defaultArgs) == 0 {
+			return fmt.Errorf("must not be empty")
+		}
+		return nil
+	},
+	logFileAnnotation: func(config *containerConfig, value string) (err error) {
+		config.logFile, err = strconv.ParseBool(value)
+		return err
+	},
+	execInheritEnvAnnotation: func(config *containerConfig, value string) (err error) {
+		config.execInheritEnv, err = strconv.ParseBool(value)
+		return err
+	},
+	validationAnnotation: func(config *containerConfig, value string) error {
+		switch mode := validationMode(value); mode {
+		case validationPermissive, validationStrict:
+			config.validation = mode
+			return nil
+		default:
+			return fmt.Errorf("must be %q or %q", validationStrict, validationPermissive)
+		}
+	},
+}
+
+// configAnnotations are annotations of config.json recognized by rund
+var configAnnotations = slices.Sorted(maps.Keys(annotationParsers))
+
+// parseAnnotations returns the container configuration set by annotations.
+// Unknown and invalid rund annotations are errors, other annotations are ignored.
+func parseAnnotations(annotations map[string]string) (*containerConfig, error) {
+	config := &containerConfig{}
+
+	var errs []string
+	for _, key := range slices.Sorted(maps.Keys(annotations)) {
+		if !strings.HasPrefix(key, annotationPrefix) {
+			continue
+		}
+
+		parse, ok := annotationParsers[key]
+		if !ok {
+			errs = append(errs, fmt.Sprintf("unknown annotation %s, known annotations are %s", key, strings.Join(configAnnotations, ", ")))
+			continue
+		}
+
+		if err := parse(config, annotations[key]); err != nil {
+			errs = append(errs, fmt.Sprintf("invalid %s annotation value %q: %v", key, annotations[key], err))
+		}
+	}
+
+	if len(errs) > 0 {
+		return nil, errgrpc.ToGRPCf(errdefs.ErrInvalidArgument, "%s", strings.Join(errs, "; "))
+	}
+
+	return config, nil
+}
+
+// validationMode returns the validation mode requested by annotations, or defaultMode if there is none.
+func (c *containerConfig) validationMode(defaultMode validationMode) validationMode {
+	if c.validation == "" {
+		return defaultMode
+	}
+	return c.validation
+}
+
+func parseSocketForwards(value string) ([]socketForward, error) {
+	var forwards []socketForward
+	for _, pair := range strings.Split(value, ",") {
+		containerPath, hostPath, ok := strings.Cut(pair, "=")
+		if !ok {
+			return nil, fmt.Errorf("%q must be container-path=host-path", pair)
+		}
+
+		if !filepath.IsAbs(containerPath) || !filepath.IsAbs(hostPath) {
+			return nil, fmt.Errorf("paths of %q must be absolute", pair)
+		}
+
+		containerPath = filepath.Clean(containerPath)
+		if slices.ContainsFunc(forwards, func(f socketForward) bool { return f.path == containerPath }) {
+			return nil, fmt.Errorf("%s is forwarded more than once", containerPath)
+		}
+
+		forwards = append(forwards, socketForward{path: containerPath, hostPath: filepath.Clean(hostPath)})
+	}
+
+	return forwards, nil
+}
+
+func parseLimit(value string) (uint64, error) {
+	limit, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+
+	if limit == 0 {
+		return 0, fmt.Errorf("must be positive")
+	}
+
+	return limit, nil
+}
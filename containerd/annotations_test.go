@@ -0,0 +1,333 @@
+package containerd
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	taskAPI "github.com/containerd/containerd/api/runtime/task/v3"
+	"github.com/containerd/errdefs"
+	"github.com/containerd/errdefs/pkg/errgrpc"
+	"github.com/darwin-containers/rund/internal/testutil"
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseAnnotations(t *testing.T) {
+	for _, tc := range []struct {
+		name        string
+		annotations map[string]string
+		expected    *containerConfig
+		err         string
+	}{
+		{
+			name:     "none",
+			expected: &containerConfig{},
+		},
+		{
+			name:        "other annotations",
+			annotations: map[string]string{"org.example": "value", "io.rund": "value"},
+			expected:    &containerConfig{},
+		},
+		{
+			name: "all",
+			annotations: map[string]string{
+				socketsAnnotation:        "/var/run/docker.sock=/var/run/docker.sock,/run/agent/../ssh.sock=/tmp/ssh.sock",
+				stopTimeoutAnnotation:    "10s",
+				memoryLimitAnnotation:    "1073741824",
+				pidsLimitAnnotation:      "100",
+				defaultArgsAnnotation:    `["/bin/sh", "-l"]`,
+				logFileAnnotation:        "true",
+				execInheritEnvAnnotation: "true",
+				validationAnnotation:     "strict",
+			},
+			expected: &containerConfig{
+				sockets: []socketForward{
+					{path: "/var/run/docker.sock", hostPath: "/var/run/docker.sock"},
+					{path: "/run/ssh.sock", hostPath: "/tmp/ssh.sock"},
+				},
+				stopTimeout:    10 * time.Second,
+				limits:         processLimits{memory: 1 << 30, pids: 100},
+				defaultArgs:    []string{"/bin/sh", "-l"},
+				logFile:        true,
+				execInheritEnv: true,
+				validation:     validationStrict,
+			},
+		},
+		{
+			name:        "unknown",
+			annotations: map[string]string{"io.rund.unknown": "true"},
+			err:         "unknown annotation io.rund.unknown, known annotations are io.rund.default-args, io.rund.exec-inherit-env, io.rund.log-file",
+		},
+		{
+			name:        "socket without host path",
+			annotations: map[string]string{socketsAnnotation: "/var/run/docker.sock"},
+			err:         `"/var/run/docker.sock" must be container-path=host-path`,
+		},
+		{
+			name:        "relative socket path",
+			annotations: map[string]string{socketsAnnotation: "docker.sock=/var/run/docker.sock"},
+			err:         "must be absolute",
+		},
+		{
+			name:        "socket forwarded twice",
+			annotations: map[string]string{socketsAnnotation: "/a.sock=/b.sock,/a.sock=/c.sock"},
+			err:         "/a.sock is forwarded more than once",
+		},
+		{
+			name:        "invalid stop timeout",
+			annotations: map[string]string{stopTimeoutAnnotation: "10"},
+			err:         `invalid io.rund.stop-timeout annotation value "10"`,
+		},
+		{
+			name:        "negative stop timeout",
+			annotations: map[string]string{stopTimeoutAnnotation: "-1s"},
+			err:         "must not be negative",
+		},
+		{
+			name:        "zero limit",
+			annotations: map[string]string{pidsLimitAnnotation: "0"},
+			err:         `invalid io.rund.pids-limit annotation value "0": must be positive`,
+		},
+		{
+			name:        "limit with units",
+			annotations: map[string]string{memoryLimitAnnotation: "1g"},
+			err:         `invalid io.rund.memory-limit annotation value "1g"`,
+		},
+		{
+			name:        "default args that are not array",
+			annotations: map[string]string{defaultArgsAnnotation: "/bin/sh"},
+			err:         `invalid io.rund.default-args annotation value "/bin/sh"`,
+		},
+		{
+			name:        "empty default args",
+			annotations: map[string]string{defaultArgsAnnotation: "[]"},
+			err:         "must not be empty",
+		},
+		{
+			name:        "invalid log file",
+			annotations: map[string]string{logFileAnnotation: "sometimes"},
+			err:         `invalid io.rund.log-file annotation value "sometimes"`,
+		},
+		{
+			name:        "invalid validation mode",
+			annotations: map[string]string{validationAnnotation: "paranoid"},
+			err:         `invalid io.rund.validation annotation value "paranoid": must be "strict" or "permissive"`,
+		},
+		{
+			name:        "all errors are reported",
+			annotations: map[string]string{logFileAnnotation: "sometimes", "io.rund.unknown": "true"},
+			err:         `parsing "sometimes": invalid syntax; unknown annotation io.rund.unknown`,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			config, err := parseAnnotations(tc.annotations)
+			if tc.err != "" {
+				require.True(t, errdefs.IsInvalidArgument(errgrpc.ToNative(err)), err)
+				require.ErrorContains(t, err, tc.err)
+				return
+			}
+
+			require.NoError(t, err)
+			require.Equal(t, tc.expected, config)
+		})
+	}
+}
+
+func TestConfigValidationMode(t *testing.T) {
+	require.Equal(t, validationPermissive, (&containerConfig{}).validationMode(validationPermissive))
+	require.Equal(t, validationStrict, (&containerConfig{}).validationMode(validationStrict))
+	require.Equal(t, validationStrict, (&containerConfig{validation: validationStrict}).validationMode(validationPermissive))
+}
+
+func TestUnknownAnnotation(t *testing.T) {
+	h := newTestHarness(t)
+
+	_, err := h.service.Create(h.ctx, &taskAPI.CreateTaskRequest{
+		ID:     "unknown",
+		Bundle: testutil.NewBundle(t, &specs.Spec{Process: testProcess("true"), Annotations: map[string]string{"io.rund.unknown": "true"}}),
+	})
+	require.True(t, errdefs.IsInvalidArgument(errgrpc.ToNative(err)), err)
+	require.ErrorContains(t, err, "unknown annotation io.rund.unknown")
+}
+
+func TestSocketForwards(t *testing.T) {
+	h := newTestHarness(t)
+
+	hostPath := filepath.Join(t.TempDir(), "host.sock")
+	host, err := net.Listen("unix", hostPath)
+	require.NoError(t, err)
+	defer host.Close()
+
+	_, err = h.service.Create(h.ctx, &taskAPI.CreateTaskRequest{
+		ID: "test",
+		Bundle: testutil.NewBundle(t, &specs.Spec{
+			Process:     testProcess("sleep", "60"),
+			Annotations: map[string]string{socketsAnnotation: "/run/test.sock=" + hostPath},
+		}),
+		Options: marshalOptions(t, &Options{AllowedSockets: []string{hostPath}}),
+	})
+	require.NoError(t, err)
+	h.start("test", "")
+
+	c, err := h.service.getContainerL("test")
+	require.NoError(t, err)
+
+	containerPath := filepath.Join(c.rootfs, "run", "test.sock")
+	conn, err := net.Dial("unix", containerPath)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	_, err = conn.Write([]byte("ping"))
+	require.NoError(t, err)
+
+	accepted, err := host.Accept()
+	require.NoError(t, err)
+	defer accepted.Close()
+
+	b := make([]byte, 4)
+	_, err = accepted.Read(b)
+	require.NoError(t, err)
+	require.Equal(t, "ping", string(b))
+
+	// mDNSResponder is forwarded along with sockets of annotations
+	mDNSResponderPath := filepath.Join(c.rootfs, "var", "run", "mDNSResponder")
+	_, err = os.Stat(mDNSResponderPath)
+	require.NoError(t, err)
+
+	h.kill("test", "", syscall.SIGKILL)
+	h.wait("test", "")
+	h.delete("test", "")
+
+	for _, p := range []string{containerPath, mDNSResponderPath} {
+		_, err = os.Stat(p)
+		require.ErrorIs(t, err, os.ErrNotExist)
+	}
+}
+
+func TestSocketForwardsNotAllowed(t *testing.T) {
+	h := newTestHarness(t)
+
+	spec := &specs.Spec{
+		Process:     testProcess("sleep", "60"),
+		Annotations: map[string]string{socketsAnnotation: "/var/run/docker.sock=/var/run/docker.sock"},
+	}
+
+	// No host sockets are allowed by default
+	_, err := h.service.Create(h.ctx, &taskAPI.CreateTaskRequest{ID: "test", Bundle: testutil.NewBundle(t, spec)})
+	require.True(t, errdefs.IsInvalidArgument(errgrpc.ToNative(err)), err)
+	require.ErrorContains(t, err, "host socket /var/run/docker.sock of io.rund.sockets annotation is not in AllowedSockets option")
+
+	_, err = h.service.Create(h.ctx, &taskAPI.CreateTaskRequest{
+		ID:      "test",
+		Bundle:  testutil.NewBundle(t, spec),
+		Options: marshalOptions(t, &Options{AllowedSockets: []string{"/var/run/other.sock"}}),
+	})
+	require.True(t, errdefs.IsInvalidArgument(errgrpc.ToNative(err)), err)
+
+	_, err = h.service.State(h.ctx, &taskAPI.StateRequest{ID: "test"})
+	require.True(t, errdefs.IsNotFound(errgrpc.ToNative(err)), err)
+}
+
+func TestSocketForwardsInRootfs(t *testing.T) {
+	h := newTestHarness(t)
+
+	hostPath := filepath.Join(t.TempDir(), "host.sock")
+	host, err := net.Listen("unix", hostPath)
+	require.NoError(t, err)
+	defer host.Close()
+
+	// Absolute symlink of the image points into the rootfs, not to the host
+	hostDir := t.TempDir()
+	bundle := testutil.NewBundle(t, &specs.Spec{
+		Process:     testProcess("sleep", "60"),
+		Annotations: map[string]string{socketsAnnotation: "/run/test.sock=" + hostPath + ",/data/keep=" + hostPath},
+	})
+	rootfs := filepath.Join(bundle, "rootfs")
+	require.NoError(t, os.Symlink(hostDir, filepath.Join(rootfs, "run")))
+	writeFile(t, filepath.Join(rootfs, "data", "keep"), "not a socket")
+
+	_, err = h.service.Create(h.ctx, &taskAPI.CreateTaskRequest{
+		ID:      "test",
+		Bundle:  bundle,
+		Options: marshalOptions(t, &Options{AllowedSockets: []string{hostPath}}),
+	})
+	require.NoError(t, err)
+
+	// Listening on the path of a regular file fails
+	_, err = h.service.Start(h.ctx, &taskAPI.StartRequest{ID: "test"})
+	require.Error(t, err)
+
+	stat, err := os.Lstat(filepath.Join(rootfs, hostDir, "test.sock"))
+	require.NoError(t, err)
+	require.Equal(t, os.ModeSocket, stat.Mode().Type())
+
+	entries, err := os.ReadDir(hostDir)
+	require.NoError(t, err)
+	require.Empty(t, entries)
+
+	h.delete("test", "")
+
+	// Only sockets that rund has created are removed
+	_, err = os.Stat(filepath.Join(rootfs, hostDir, "test.sock"))
+	require.ErrorIs(t, err, os.ErrNotExist)
+	require.FileExists(t, filepath.Join(rootfs, "data", "keep"))
+}
+
+func TestStopTimeout(t *testing.T) {
+	h := newTestHarness(t)
+
+	h.create("test", &specs.Spec{
+		Process:     testProcess("sleep", "60"),
+		Annotations: map[string]string{stopTimeoutAnnotation: "10s"},
+	})
+	h.start("test", "")
+
+	c, err := h.service.getContainerL("test")
+	require.NoError(t, err)
+
+	// Exec process that is still running when the container is deleted is terminated with SIGTERM
+	h.exec("test", "exec", testProcess("sh", "-c", "trap 'touch /terminated; exit 0' TERM; touch /ready; while true; do sleep 0.1; done"))
+	h.start("test", "exec")
+
+	require.Eventually(t, func() bool {
+		_, err := os.Stat(filepath.Join(c.rootfs, "ready"))
+		return err == nil
+	}, 5*time.Second, 10*time.Millisecond)
+
+	h.kill("test", "", syscall.SIGKILL)
+	h.wait("test", "")
+
+	start := time.Now()
+	h.delete("test", "")
+	require.Less(t, time.Since(start), 5*time.Second, "delete waits only until processes exit")
+
+	_, err = os.Stat(filepath.Join(c.rootfs, "terminated"))
+	require.NoError(t, err, "exec process handled SIGTERM")
+}
+
+func TestDefaultArgs(t *testing.T) {
+	h := newTestHarness(t)
+
+	// Processes without args are rejected by default
+	_, err := h.service.Create(h.ctx, &taskAPI.CreateTaskRequest{
+		ID:     "rejected",
+		Bundle: testutil.NewBundle(t, &specs.Spec{Process: testProcess()}),
+	})
+	require.True(t, errdefs.IsInvalidArgument(errgrpc.ToNative(err)), err)
+	require.ErrorContains(t, err, defaultArgsAnnotation)
+
+	// Executable of default args is looked up in rootfs
+	h.create("test", &specs.Spec{
+		Process:     testProcess(),
+		Annotations: map[string]string{defaultArgsAnnotation: `["sh", "-c", "echo default"]`},
+	})
+	output, status := h.run("test", "")
+	require.Zero(t, status)
+	require.Equal(t, "default\n", output)
+
+	h.delete("test", "")
+}
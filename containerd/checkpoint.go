@@ -0,0 +1,355 @@
+package containerd
+
+import (
+	"archive/tar"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/containerd/containerd/api/types/task"
+	"github.com/containerd/errdefs"
+	"github.com/containerd/errdefs/pkg/errgrpc"
+	"github.com/containerd/log"
+)
+
+// Checkpoints are filesystem-only: Darwin can't freeze process memory, so a checkpoint holds changes of the container rootfs
+// and restoring it starts processes from scratch on top of the restored files.
+const (
+	// checkpointRootfsName is the tar of rootfs entries that changed since the container was created
+	checkpointRootfsName = "rootfs.tar"
+	// checkpointMetadataName is the JSON encoded checkpointMetadata
+	checkpointMetadataName = "container.json"
+)
+
+// checkpointMetadata describes the checkpointed container.
+type checkpointMetadata struct {
+	ID           string            `json:"id"`
+	Created      time.Time         `json:"created"`
+	Checkpointed time.Time         `json:"checkpointed"`
+	Status       string            `json:"status"`
+	Annotations  map[string]string `json:"annotations,omitempty"`
+	// Directories lists entries of directories that changed since the container was created,
+	// entries that are missing from the lists have been removed
+	Directories map[string][]string `json:"directories"`
+}
+
+// checkpoint writes rootfs changes and metadata of a stopped or paused container into directory path.
+// Mounts of the spec aren't part of rootfs and are skipped.
+func (c *container) checkpoint(ctx context.Context, path string) (err error) {
+	ctx, span := startSpan(ctx, "container.checkpoint")
+	defer func() {
+		endSpan(span, err)
+	}()
+
+	if err = c.lock(); err != nil {
+		return err
+	}
+	defer c.unlock()
+
+	status := c.primary.state.get().status
+	if err = c.primary.state.require("checkpoint", task.Status_CREATED, task.Status_PAUSED, task.Status_STOPPED); err != nil {
+		return err
+	}
+
+	if err = os.MkdirAll(path, 0o700); err != nil {
+		return err
+	}
+
+	metadata := checkpointMetadata{
+		ID:           c.id,
+		Created:      c.created,
+		Checkpointed: time.Now(),
+		Status:       status.String(),
+		Annotations:  c.spec.Annotations,
+		Directories:  make(map[string][]string),
+	}
+
+	f, err := os.Create(filepath.Join(path, checkpointRootfsName))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	tw := tar.NewWriter(f)
+	if err = c.writeChanges(ctx, tw, metadata.Directories); err != nil {
+		return fmt.Errorf("failed to write rootfs changes: %w", err)
+	}
+	if err = tw.Close(); err != nil {
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(metadata)
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(filepath.Join(path, checkpointMetadataName), data, 0o600)
+}
+
+// writeChanges writes changed rootfs entries to tw and lists entries of changed directories into directories.
+//
+// Rootfs of rund is a plain directory without lower layers to diff against, so changes are found by a heuristic:
+// entries whose status change time is not older than the second the container was created in are changes.
+// Any write, chmod, chown or rename of an entry updates it, setting modification time back doesn't.
+// Entries of the image that were changed in the second of creation before the container was created are included as well,
+// which only makes the checkpoint larger.
+func (c *container) writeChanges(ctx context.Context, tw *tar.Writer, directories map[string][]string) error {
+	// Destinations are resolved inside rootfs, so destinations behind symlinks of the image are skipped as well
+	mounts := make(map[string]bool)
+	for _, m := range c.spec.Mounts {
+		destination, err := rootfsPath(c.rootfs, m.Destination)
+		if err != nil {
+			return err
+		}
+		mounts[destination] = true
+	}
+
+	// Timestamps of files may be coarser than the clock, so changes made in the second of creation are included
+	since := c.created.Truncate(time.Second)
+
+	return filepath.WalkDir(c.rootfs, func(hostPath string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if mounts[hostPath] {
+			if d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(c.rootfs, hostPath)
+		if err != nil {
+			return err
+		}
+		name := filepath.Join("/", rel)
+
+		if changeTime(info).Before(since) {
+			return nil
+		}
+
+		if d.IsDir() {
+			entries, err := os.ReadDir(hostPath)
+			if err != nil {
+				return err
+			}
+
+			names := []string{}
+			for _, entry := range entries {
+				names = append(names, entry.Name())
+			}
+			directories[name] = names
+
+			// Rootfs itself isn't an entry of the tar
+			if name == "/" {
+				return nil
+			}
+		}
+
+		var link string
+		switch {
+		case info.Mode().IsDir(), info.Mode().IsRegular():
+		case info.Mode()&fs.ModeSymlink != 0:
+			if link, err = os.Readlink(hostPath); err != nil {
+				return err
+			}
+		default:
+			log.G(ctx).WithField("path", name).Debugf("skipping entry of type %s in checkpoint", info.Mode().Type())
+			return nil
+		}
+
+		header, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return err
+		}
+		header.Name = rel
+		if d.IsDir() {
+			header.Name += "/"
+		}
+
+		if err = tw.WriteHeader(header); err != nil {
+			return err
+		}
+
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		file, err := os.Open(hostPath)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+
+		_, err = io.Copy(tw, file)
+		return err
+	})
+}
+
+// restoreCheckpoint applies rootfs changes of the checkpoint in directory path to rootfs.
+// Paths are resolved inside rootfs, so symlinks of the checkpoint can't point outside of it.
+func restoreCheckpoint(rootfs, path string) error {
+	data, err := os.ReadFile(filepath.Join(path, checkpointMetadataName))
+	if err != nil {
+		return errgrpc.ToGRPCf(errdefs.ErrInvalidArgument, "invalid checkpoint: %v", err)
+	}
+
+	var metadata checkpointMetadata
+	if err = json.Unmarshal(data, &metadata); err != nil {
+		return errgrpc.ToGRPCf(errdefs.ErrInvalidArgument, "invalid checkpoint %s: %v", checkpointMetadataName, err)
+	}
+
+	// Entries removed after the checkpointed container had been created are removed first,
+	// entries that replace them are restored from the tar afterwards
+	for dir, names := range metadata.Directories {
+		if err = removeEntries(rootfs, dir, names); err != nil {
+			return fmt.Errorf("failed to restore %s: %w", dir, err)
+		}
+	}
+
+	f, err := os.Open(filepath.Join(path, checkpointRootfsName))
+	if err != nil {
+		return errgrpc.ToGRPCf(errdefs.ErrInvalidArgument, "invalid checkpoint: %v", err)
+	}
+	defer f.Close()
+
+	// Times of directories are restored last, restoring their entries changes them
+	var dirs []*tar.Header
+
+	tr := tar.NewReader(f)
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return errgrpc.ToGRPCf(errdefs.ErrInvalidArgument, "invalid checkpoint %s: %v", checkpointRootfsName, err)
+		}
+
+		if err = restoreEntry(rootfs, header, tr); err != nil {
+			return fmt.Errorf("failed to restore %s: %w", header.Name, err)
+		}
+
+		if header.Typeflag == tar.TypeDir {
+			dirs = append(dirs, header)
+		}
+	}
+
+	for _, header := range slices.Backward(dirs) {
+		target, err := rootfsPath(rootfs, header.Name)
+		if err != nil {
+			return err
+		}
+		if err = os.Chtimes(target, header.ModTime, header.ModTime); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// removeEntries removes entries of directory dir inside rootfs that aren't in names.
+func removeEntries(rootfs, dir string, names []string) error {
+	resolved, err := rootfsPath(rootfs, dir)
+	if err != nil {
+		return err
+	}
+
+	entries, err := os.ReadDir(resolved)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		if !slices.Contains(names, entry.Name()) {
+			if err = os.RemoveAll(filepath.Join(resolved, entry.Name())); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// restoreEntry restores a tar entry inside rootfs, replacing the existing one.
+func restoreEntry(rootfs string, header *tar.Header, r io.Reader) error {
+	name := filepath.Join("/", header.Name)
+	if name == "/" {
+		return nil
+	}
+
+	// The entry itself is replaced, so only its parent is resolved
+	parent, err := rootfsPath(rootfs, filepath.Dir(name))
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(parent, 0o755); err != nil {
+		return err
+	}
+	target := filepath.Join(parent, filepath.Base(name))
+
+	existing, err := os.Lstat(target)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if err == nil && (header.Typeflag != tar.TypeDir || !existing.IsDir()) {
+		if err = os.RemoveAll(target); err != nil {
+			return err
+		}
+	}
+
+	switch header.Typeflag {
+	case tar.TypeDir:
+		if err = os.Mkdir(target, 0o755); err != nil && !errors.Is(err, os.ErrExist) {
+			return err
+		}
+	case tar.TypeReg:
+		f, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(f, r)
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return err
+		}
+	case tar.TypeSymlink:
+		if err = os.Symlink(header.Linkname, target); err != nil {
+			return err
+		}
+		return os.Lchown(target, header.Uid, header.Gid)
+	default:
+		return fmt.Errorf("unsupported entry type %q", header.Typeflag)
+	}
+
+	// Ownership is restored before mode, because chown clears setuid and setgid bits
+	if err = os.Lchown(target, header.Uid, header.Gid); err != nil {
+		return err
+	}
+	if err = os.Chmod(target, header.FileInfo().Mode()); err != nil {
+		return err
+	}
+
+	return os.Chtimes(target, header.ModTime, header.ModTime)
+}
@@ -0,0 +1,13 @@
+package containerd
+
+import (
+	"os"
+	"syscall"
+	"time"
+)
+
+// changeTime returns the time when status of the file changed last
+func changeTime(info os.FileInfo) time.Time {
+	stat := info.Sys().(*syscall.Stat_t)
+	return time.Unix(stat.Ctimespec.Unix())
+}
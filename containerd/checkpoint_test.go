@@ -0,0 +1,168 @@
+package containerd
+
+import (
+	"archive/tar"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"syscall"
+	"testing"
+	"time"
+
+	taskAPI "github.com/containerd/containerd/api/runtime/task/v3"
+	"github.com/containerd/containerd/api/types/task"
+	"github.com/containerd/containerd/v2/pkg/oci"
+	"github.com/containerd/errdefs"
+	"github.com/containerd/errdefs/pkg/errgrpc"
+	"github.com/darwin-containers/rund/internal/testutil"
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/stretchr/testify/require"
+)
+
+func writeCheckpointBase(t *testing.T, rootfs string) {
+	writeFile(t, filepath.Join(rootfs, "etc", "kept"), "kept")
+	writeFile(t, filepath.Join(rootfs, "etc", "changed"), "old")
+	writeFile(t, filepath.Join(rootfs, "removed"), "removed")
+	writeFile(t, filepath.Join(rootfs, "var", "cache", "old", "file"), "old")
+}
+
+func tarNames(t *testing.T, path string) []string {
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	defer f.Close()
+
+	var names []string
+	tr := tar.NewReader(f)
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return names
+		}
+		require.NoError(t, err)
+		names = append(names, header.Name)
+	}
+}
+
+func TestCheckpointRestore(t *testing.T) {
+	rootfs := t.TempDir()
+	writeCheckpointBase(t, rootfs)
+
+	// Files of the base predate the second the container is created in
+	time.Sleep(time.Until(time.Now().Truncate(time.Second).Add(time.Second + 10*time.Millisecond)))
+
+	c := &container{
+		id: "test",
+		spec: &oci.Spec{Mounts: []specs.Mount{
+			{Type: mountTypeBind, Destination: "/mnt/host"},
+			{Type: mountTypeBind, Destination: "/data/cache"},
+		}},
+		rootfs:  rootfs,
+		primary: newManagedProcess(&specs.Process{}, true, processLimits{}),
+		config:  &containerConfig{},
+		created: time.Now(),
+	}
+
+	writeFile(t, filepath.Join(rootfs, "etc", "changed"), "new")
+	require.NoError(t, os.Remove(filepath.Join(rootfs, "removed")))
+	require.NoError(t, os.RemoveAll(filepath.Join(rootfs, "var", "cache", "old")))
+	writeExecutable(t, filepath.Join(rootfs, "work", "run.sh"))
+	require.NoError(t, os.Symlink("/etc/changed", filepath.Join(rootfs, "work", "link")))
+	// Mounts of the spec aren't part of the checkpoint, also when their destination is behind a symlink
+	writeFile(t, filepath.Join(rootfs, "mnt", "host", "file"), "host")
+	require.NoError(t, os.Symlink("/var/lib", filepath.Join(rootfs, "data")))
+	writeFile(t, filepath.Join(rootfs, "var", "lib", "cache", "file"), "host")
+
+	checkpoint := filepath.Join(t.TempDir(), "checkpoint")
+
+	// Running containers can't be checkpointed
+	c.primary.state.status = task.Status_RUNNING
+	err := c.checkpoint(t.Context(), checkpoint)
+	require.True(t, errdefs.IsFailedPrecondition(errgrpc.ToNative(err)), err)
+
+	c.primary.state.status = task.Status_STOPPED
+	require.NoError(t, c.checkpoint(t.Context(), checkpoint))
+	require.Equal(t,
+		[]string{"data", "etc/changed", "mnt/", "var/", "var/cache/", "var/lib/", "work/", "work/link", "work/run.sh"},
+		tarNames(t, filepath.Join(checkpoint, checkpointRootfsName)))
+
+	restored := t.TempDir()
+	writeCheckpointBase(t, restored)
+	require.NoError(t, restoreCheckpoint(restored, checkpoint))
+
+	for path, expected := range map[string]string{"etc/kept": "kept", "etc/changed": "new"} {
+		data, err := os.ReadFile(filepath.Join(restored, path))
+		require.NoError(t, err)
+		require.Equal(t, expected, string(data), path)
+	}
+
+	for _, path := range []string{"removed", "var/cache/old", "mnt/host", "var/lib/cache"} {
+		_, err := os.Lstat(filepath.Join(restored, path))
+		require.ErrorIs(t, err, os.ErrNotExist, path)
+	}
+
+	link, err := os.Readlink(filepath.Join(restored, "work", "link"))
+	require.NoError(t, err)
+	require.Equal(t, "/etc/changed", link)
+
+	stat, err := os.Stat(filepath.Join(restored, "work", "run.sh"))
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0o755), stat.Mode().Perm())
+
+	_, err = os.Stat(filepath.Join(restored, "var", "cache"))
+	require.NoError(t, err)
+
+	err = restoreCheckpoint(restored, t.TempDir())
+	require.True(t, errdefs.IsInvalidArgument(errgrpc.ToNative(err)), err)
+}
+
+func TestCheckpoint(t *testing.T) {
+	h := newTestHarness(t)
+
+	h.create("test", &specs.Spec{Process: testProcess("sleep", "60")})
+	h.start("test", "")
+
+	h.exec("test", "exec", testProcess("sh", "-c", "echo checkpointed > /data; mkdir -p /opt/host"))
+	_, status := h.run("test", "exec")
+	require.Zero(t, status)
+
+	checkpoint := filepath.Join(t.TempDir(), "checkpoint")
+	_, err := h.service.Checkpoint(h.ctx, &taskAPI.CheckpointTaskRequest{ID: "test", Path: checkpoint})
+	require.True(t, errdefs.IsFailedPrecondition(errgrpc.ToNative(err)), err)
+
+	h.kill("test", "", syscall.SIGKILL)
+	h.wait("test", "")
+
+	_, err = h.service.Checkpoint(h.ctx, &taskAPI.CheckpointTaskRequest{ID: "test", Path: checkpoint})
+	require.NoError(t, err)
+	h.delete("test", "exec")
+	h.delete("test", "")
+
+	// Files of the checkpoint are restored before the process starts, and before mounts of the spec,
+	// so entries missing from the checkpointed /opt/host are not removed from the host directory mounted there
+	hostDir := t.TempDir()
+	writeFile(t, filepath.Join(hostDir, "file"), "host")
+
+	_, err = h.service.Create(h.ctx, &taskAPI.CreateTaskRequest{
+		ID: "restored",
+		Bundle: testutil.NewBundle(t, &specs.Spec{
+			Process: testProcess("cat", "/data", "/opt/host/file"),
+			Mounts:  []specs.Mount{{Type: "bind", Source: hostDir, Destination: "/opt/host", Options: []string{"rbind", "ro"}}},
+		}),
+		Stdout:     h.stdout("restored", ""),
+		Checkpoint: checkpoint,
+	})
+	require.NoError(t, err)
+
+	output, status := h.run("restored", "")
+	require.Zero(t, status)
+	require.Equal(t, "checkpointed\nhost", output)
+	require.FileExists(t, filepath.Join(hostDir, "file"))
+
+	h.delete("restored", "")
+
+	require.Eventually(t, func() bool {
+		return slices.Contains(h.publisher.recorded(), "/tasks/checkpointed")
+	}, 5*time.Second, 10*time.Millisecond)
+}
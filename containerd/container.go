@@ -1,41 +1,101 @@
 package containerd
 
 import (
+	"context"
 	"errors"
+	"maps"
+	"net"
 	"os"
+	"slices"
 	"sync"
+	"syscall"
+	"time"
 
+	"github.com/containerd/containerd/api/types/task"
 	"github.com/containerd/containerd/v2/core/mount"
 	"github.com/containerd/containerd/v2/pkg/oci"
 	"github.com/containerd/errdefs"
 	"github.com/containerd/errdefs/pkg/errgrpc"
-	"golang.org/x/sys/unix"
 )
 
-const unmountFlags = unix.MNT_FORCE
+// mDNSResponderSocket is the path of mDNSResponder socket both on the host and in containers
+const mDNSResponderSocket = "/var/run/mDNSResponder"
+
+// forwardedSocket is a socket in the container rootfs whose connections are forwarded to a host socket.
+type forwardedSocket struct {
+	socketForward
+	// listenPath is the path of the socket resolved in the rootfs and relative to the shim working dir,
+	// to fit into the 104-char limit of UNIX socket paths. It is set once the rootfs is mounted.
+	listenPath string
+	// listener is guarded by lifecycle lock
+	listener net.Listener
+}
 
 type container struct {
 	// These fields are readonly and filled when container is created
-	spec          *oci.Spec
-	bundlePath    string
-	rootfs        string
-	dnsSocketPath string
-
+	id         string
+	spec       *oci.Spec
+	bundlePath string
+	rootfs     string
+	sockets    []*forwardedSocket
+	mounts     []mount.Mount
+	options    *Options
+	config     *containerConfig
+	metrics    *metrics
+	// created is when the container was created, checkpoints hold rootfs changes made since then
+	created time.Time
+
+	// lifecycle serializes state transitions of the container and its processes,
+	// such as starting, exec, pause and teardown.
+	// It may be held for a long time while mounting or unmounting, so it is never acquired while holding mu or service.mu.
+	// Lookups of processes don't need it.
+	lifecycle sync.Mutex
+
+	// deleted is set under lifecycle lock once the container is destroyed
+	deleted bool
+
+	proxy proxyStats
+
+	// mu guards auxiliary map, modifications also require lifecycle lock
 	mu sync.Mutex
 
 	// primary is the primary process for the container.
 	// The lifetime of the container is tied to this process.
-	primary managedProcess
+	primary *managedProcess
 
 	// auxiliary is a map of additional processes that run in the jail.
 	auxiliary map[string]*managedProcess
 }
 
-func (c *container) destroy() error {
+// lock acquires lifecycle lock of the container.
+// It fails if the container has been deleted while waiting for the lock.
+func (c *container) lock() error {
+	c.lifecycle.Lock()
+
+	if c.deleted {
+		c.lifecycle.Unlock()
+		return errgrpc.ToGRPCf(errdefs.ErrNotFound, "container not created")
+	}
+
+	return nil
+}
+
+func (c *container) unlock() {
+	c.lifecycle.Unlock()
+}
+
+// destroy must be called with lifecycle lock held, or before the container is added to the service.
+func (c *container) destroy(ctx context.Context) (err error) {
+	_, span := startSpan(ctx, "container.destroy")
+	defer func() {
+		endSpan(span, err)
+	}()
+
 	var errs []error
 
-	c.mu.Lock()
-	defer c.mu.Unlock()
+	if c.config.stopTimeout > 0 {
+		c.stop(c.config.stopTimeout)
+	}
 
 	for _, p := range c.auxiliary {
 		if err := p.destroy(); err != nil {
@@ -47,16 +107,105 @@ func (c *container) destroy() error {
 		errs = append(errs, err)
 	}
 
-	// Remove socket file to avoid continuity "failed to create irregular file" error during multiple Dockerfile  `RUN` steps
-	_ = os.Remove(c.dnsSocketPath)
+	for _, socket := range c.sockets {
+		// Files of sockets that the shim hasn't created are left alone
+		if socket.listener == nil {
+			continue
+		}
+		_ = socket.listener.Close()
+
+		// Remove socket file to avoid continuity "failed to create irregular file" error during multiple Dockerfile  `RUN` steps
+		_ = os.Remove(socket.listenPath)
+	}
 
-	if err := mount.UnmountRecursive(c.rootfs, unmountFlags); err != nil {
+	start := time.Now()
+	if err := mount.UnmountRecursive(c.rootfs, c.options.unmountFlags()); err != nil {
 		errs = append(errs, err)
 	}
+	c.metrics.unmount.observe(time.Since(start))
 
 	return errors.Join(errs...)
 }
 
+// stop sends SIGTERM to running processes of the container and waits up to timeout for them to exit.
+// It must be called with lifecycle lock held.
+func (c *container) stop(timeout time.Duration) {
+	var running []*managedProcess
+	for _, p := range append(slices.Collect(maps.Values(c.auxiliary)), c.primary) {
+		switch p.state.get().status {
+		case task.Status_RUNNING:
+			_ = p.kill(syscall.SIGTERM)
+		case task.Status_PAUSED:
+			_ = p.kill(syscall.SIGTERM)
+			_ = p.kill(syscall.SIGCONT)
+		default:
+			continue
+		}
+		running = append(running, p)
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	for _, p := range running {
+		select {
+		case <-p.waitblock:
+		case <-timer.C:
+			return
+		}
+	}
+}
+
+// pause stops all processes of the container.
+func (c *container) pause() error {
+	if err := c.lock(); err != nil {
+		return err
+	}
+	defer c.unlock()
+
+	if err := c.primary.state.pause(); err != nil {
+		return err
+	}
+	_ = c.primary.kill(syscall.SIGSTOP)
+
+	for _, p := range c.auxiliary {
+		if p.state.pause() == nil {
+			_ = p.kill(syscall.SIGSTOP)
+		}
+	}
+
+	return nil
+}
+
+// resume continues all processes of the container stopped by pause.
+func (c *container) resume() error {
+	if err := c.lock(); err != nil {
+		return err
+	}
+	defer c.unlock()
+
+	if err := c.primary.state.resume(); err != nil {
+		return err
+	}
+	_ = c.primary.kill(syscall.SIGCONT)
+
+	for _, p := range c.auxiliary {
+		if p.state.resume() == nil {
+			_ = p.kill(syscall.SIGCONT)
+		}
+	}
+
+	return nil
+}
+
+// defaultArgs returns args of processes whose spec sets none.
+func (c *container) defaultArgs() []string {
+	if c.config.defaultArgs != nil {
+		return c.config.defaultArgs
+	}
+	return c.options.DefaultArgs
+}
+
 func (c *container) getProcessL(execID string) (*managedProcess, error) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
@@ -66,7 +215,7 @@ func (c *container) getProcessL(execID string) (*managedProcess, error) {
 
 func (c *container) getProcess(execID string) (*managedProcess, error) {
 	if execID == "" {
-		return &c.primary, nil
+		return c.primary, nil
 	}
 
 	p := c.auxiliary[execID]
@@ -0,0 +1,162 @@
+package containerd
+
+import (
+	"encoding/json"
+	"maps"
+	"net/http"
+	"net/http/pprof"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/containerd/containerd/v2/plugins"
+	"github.com/containerd/log"
+	"github.com/containerd/plugin"
+	"github.com/containerd/plugin/registry"
+)
+
+func init() {
+	// The shim serves the HTTP handler with this id on its debug socket when started with -debug
+	registry.Register(&plugin.Registration{
+		Type: plugins.HTTPHandler,
+		ID:   "pprof",
+		Requires: []plugin.Type{
+			plugins.TTRPCPlugin,
+		},
+		InitFn: func(ic *plugin.InitContext) (interface{}, error) {
+			ts, err := ic.GetByID(plugins.TTRPCPlugin, "task")
+			if err != nil {
+				return nil, err
+			}
+			return newDebugServer(ts.(*service)), nil
+		},
+	})
+}
+
+// newDebugServer serves pprof, a dump of containers at /debug/containers and metrics in Prometheus format at /metrics.
+func newDebugServer(s *service) *http.Server {
+	m := http.NewServeMux()
+	m.Handle("/debug/pprof/", http.HandlerFunc(pprof.Index))
+	m.Handle("/debug/pprof/cmdline", http.HandlerFunc(pprof.Cmdline))
+	m.Handle("/debug/pprof/profile", http.HandlerFunc(pprof.Profile))
+	m.Handle("/debug/pprof/symbol", http.HandlerFunc(pprof.Symbol))
+	m.Handle("/debug/pprof/trace", http.HandlerFunc(pprof.Trace))
+
+	m.HandleFunc("/debug/containers", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(s.dumpContainers()); err != nil {
+			log.G(r.Context()).WithError(err).Warn("failed to write containers dump")
+		}
+	})
+
+	m.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+
+		if err := s.writeMetrics(w); err != nil {
+			log.G(r.Context()).WithError(err).Warn("failed to write metrics")
+		}
+	})
+
+	return &http.Server{
+		Handler:           m,
+		ReadHeaderTimeout: 5 * time.Minute,
+	}
+}
+
+type containerDump struct {
+	ID        string        `json:"id"`
+	Bundle    string        `json:"bundle"`
+	Rootfs    string        `json:"rootfs"`
+	Mounts    []mountDump   `json:"mounts"`
+	Sockets   []socketDump  `json:"sockets"`
+	Proxy     proxyDump     `json:"proxy"`
+	Processes []processDump `json:"processes"`
+}
+
+type mountDump struct {
+	Type    string   `json:"type"`
+	Source  string   `json:"source"`
+	Target  string   `json:"target"`
+	Options []string `json:"options,omitempty"`
+}
+
+type socketDump struct {
+	Path     string `json:"path"`
+	HostPath string `json:"host_path"`
+}
+
+type proxyDump struct {
+	Active int64  `json:"active"`
+	Total  uint64 `json:"total"`
+}
+
+type processDump struct {
+	ExecID     string     `json:"exec_id,omitempty"`
+	Args       []string   `json:"args"`
+	Status     string     `json:"status"`
+	Pid        int        `json:"pid,omitempty"`
+	ExitStatus uint32     `json:"exit_status,omitempty"`
+	ExitedAt   *time.Time `json:"exited_at,omitempty"`
+}
+
+// dumpContainers returns a snapshot of containers and their processes sorted by ids.
+func (s *service) dumpContainers() []containerDump {
+	dumps := []containerDump{}
+	for _, c := range s.listContainers() {
+		dump := containerDump{
+			ID:      c.id,
+			Bundle:  c.bundlePath,
+			Rootfs:  c.rootfs,
+			Mounts:  []mountDump{},
+			Sockets: []socketDump{},
+			Proxy: proxyDump{
+				Active: c.proxy.active.Load(),
+				Total:  c.proxy.total.Load(),
+			},
+			Processes: []processDump{dumpProcess("", c.primary)},
+		}
+
+		for _, socket := range c.sockets {
+			dump.Sockets = append(dump.Sockets, socketDump{Path: socket.path, HostPath: socket.hostPath})
+		}
+
+		for _, m := range c.mounts {
+			dump.Mounts = append(dump.Mounts, mountDump{
+				Type:    m.Type,
+				Source:  m.Source,
+				Target:  m.Target,
+				Options: m.Options,
+			})
+		}
+
+		c.mu.Lock()
+		for _, execID := range slices.Sorted(maps.Keys(c.auxiliary)) {
+			dump.Processes = append(dump.Processes, dumpProcess(execID, c.auxiliary[execID]))
+		}
+		c.mu.Unlock()
+
+		dumps = append(dumps, dump)
+	}
+
+	return dumps
+}
+
+func dumpProcess(execID string, p *managedProcess) processDump {
+	st := p.state.get()
+
+	dump := processDump{
+		ExecID:     execID,
+		Args:       p.spec.Args,
+		Status:     strings.ToLower(st.status.String()),
+		Pid:        st.pid,
+		ExitStatus: st.exitStatus,
+	}
+	if !st.exitedAt.IsZero() {
+		dump.ExitedAt = &st.exitedAt
+	}
+
+	return dump
+}
@@ -0,0 +1,81 @@
+package containerd
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/containerd/containerd/api/types/task"
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/stretchr/testify/require"
+)
+
+// get requests path from the debug server of s and returns the response body.
+func get(t *testing.T, s *service, path string) string {
+	recorder := httptest.NewRecorder()
+	newDebugServer(s).Handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, path, nil))
+	require.Equal(t, http.StatusOK, recorder.Code)
+
+	return recorder.Body.String()
+}
+
+func TestMetrics(t *testing.T) {
+	s := newTestService(t, task.Status_RUNNING)
+	s.containers["test"].auxiliary["exec"] = newManagedProcess(&specs.Process{}, true, processLimits{})
+	s.containers["test"].proxy.active.Add(2)
+	s.metrics.proxyConnections.Add(5)
+	s.metrics.mount.observe(1500 * time.Millisecond)
+	s.metrics.mount.observe(500 * time.Millisecond)
+	s.events.send(struct{}{})
+
+	// Reserved ids of containers that are being created are not counted
+	s.containers["creating"] = nil
+
+	metrics := get(t, s, "/metrics")
+	for _, line := range []string{
+		"# TYPE rund_containers gauge",
+		"rund_containers 1",
+		"rund_execs 1",
+		"rund_event_queue_depth 1",
+		"rund_events_dropped_total 0",
+		"rund_proxy_connections 2",
+		"rund_proxy_connections_total 5",
+		"# TYPE rund_mount_duration_seconds summary",
+		"rund_mount_duration_seconds_sum 2",
+		"rund_mount_duration_seconds_count 2",
+		"rund_unmount_duration_seconds_count 0",
+	} {
+		require.Contains(t, strings.Split(metrics, "\n"), line)
+	}
+}
+
+func TestDumpContainers(t *testing.T) {
+	h := newTestHarness(t)
+
+	h.create("test", &specs.Spec{Process: testProcess("sleep", "60")})
+	pid := h.start("test", "")
+	h.exec("test", "exec", testProcess("true"))
+
+	var dumps []containerDump
+	require.NoError(t, json.Unmarshal([]byte(get(t, h.service, "/debug/containers")), &dumps))
+	require.Len(t, dumps, 1)
+
+	dump := dumps[0]
+	require.Equal(t, "test", dump.ID)
+	require.NotEmpty(t, dump.Rootfs)
+	require.Equal(t, []processDump{
+		{Args: []string{"sleep", "60"}, Status: "running", Pid: int(pid)},
+		{ExecID: "exec", Args: []string{"true"}, Status: "created"},
+	}, dump.Processes)
+
+	h.kill("test", "", syscall.SIGKILL)
+	h.wait("test", "")
+	h.delete("test", "")
+
+	require.Equal(t, "[]\n", get(t, h.service, "/debug/containers"))
+	require.Contains(t, get(t, h.service, "/metrics"), "rund_unmount_duration_seconds_count 1\n")
+}
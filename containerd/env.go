@@ -0,0 +1,45 @@
+package containerd
+
+import (
+	"strings"
+)
+
+// defaultTerm is TERM of processes with terminal whose environment sets none, same as runc
+const defaultTerm = "xterm"
+
+// mergeEnv merges environments in KEY=value form, later values of a key replace earlier ones.
+// Keys keep the position of their first occurrence. Entries without a key are dropped.
+func mergeEnv(envs ...[]string) []string {
+	var result []string
+	index := make(map[string]int)
+
+	for _, env := range envs {
+		for _, kv := range env {
+			key, _, _ := strings.Cut(kv, "=")
+			if key == "" {
+				continue
+			}
+
+			if i, ok := index[key]; ok {
+				result[i] = kv
+				continue
+			}
+
+			index[key] = len(result)
+			result = append(result, kv)
+		}
+	}
+
+	return result
+}
+
+// processEnv returns environment of a process with duplicate keys resolved and defaults
+// for PATH, HOME of the process user and TERM of processes with terminal that env doesn't set.
+func processEnv(env []string, u *execUser, terminal bool) []string {
+	defaults := []string{"PATH=" + defaultPath, "HOME=" + u.home}
+	if terminal {
+		defaults = append(defaults, "TERM="+defaultTerm)
+	}
+
+	return mergeEnv(defaults, env)
+}
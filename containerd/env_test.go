@@ -0,0 +1,87 @@
+package containerd
+
+import (
+	"syscall"
+	"testing"
+
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMergeEnv(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		envs     [][]string
+		expected []string
+	}{
+		{
+			name: "empty",
+		},
+		{
+			name:     "last wins",
+			envs:     [][]string{{"A=1", "B=2", "A=3"}},
+			expected: []string{"A=3", "B=2"},
+		},
+		{
+			name:     "later environment wins",
+			envs:     [][]string{{"A=1", "B=2"}, {"B=3", "C=4"}},
+			expected: []string{"A=1", "B=3", "C=4"},
+		},
+		{
+			name:     "empty value",
+			envs:     [][]string{{"A=1"}, {"A="}},
+			expected: []string{"A="},
+		},
+		{
+			name:     "value with equals sign",
+			envs:     [][]string{{"A=b=c", "A=d=e"}},
+			expected: []string{"A=d=e"},
+		},
+		{
+			name:     "entries without key are dropped",
+			envs:     [][]string{{"=1", "", "A=1"}},
+			expected: []string{"A=1"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			require.Equal(t, tc.expected, mergeEnv(tc.envs...))
+		})
+	}
+}
+
+func TestProcessEnv(t *testing.T) {
+	u := &execUser{home: "/home/user"}
+
+	require.Equal(t, []string{"PATH=" + defaultPath, "HOME=/home/user"}, processEnv(nil, u, false))
+	require.Equal(t, []string{"PATH=" + defaultPath, "HOME=/home/user", "TERM=xterm"}, processEnv(nil, u, true))
+
+	// Environment of the process takes precedence over defaults
+	require.Equal(t,
+		[]string{"PATH=/bin", "HOME=/root", "TERM=vt100", "A=2"},
+		processEnv([]string{"A=1", "TERM=vt100", "HOME=/root", "PATH=/bin", "A=2"}, u, true))
+}
+
+func TestExecInheritEnv(t *testing.T) {
+	h := newTestHarness(t)
+
+	primary := testProcess("sleep", "60")
+	primary.Env = append(primary.Env, "A=primary", "B=primary")
+	h.create("test", &specs.Spec{
+		Process:     primary,
+		Annotations: map[string]string{execInheritEnvAnnotation: "true"},
+	})
+	h.start("test", "")
+
+	// Exec environment takes precedence over the inherited one, defaults fill in the rest
+	process := testProcess("sh", "-c", `echo "$A $B $HOME"`)
+	process.Env = append(process.Env, "B=exec")
+	h.exec("test", "exec", process)
+	output, status := h.run("test", "exec")
+	require.Zero(t, status)
+	require.Equal(t, "primary exec /\n", output)
+
+	h.delete("test", "exec")
+	h.kill("test", "", syscall.SIGKILL)
+	h.wait("test", "")
+	h.delete("test", "")
+}
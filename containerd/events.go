@@ -0,0 +1,135 @@
+package containerd
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/containerd/containerd/api/events"
+	"github.com/containerd/containerd/v2/core/runtime"
+	"github.com/containerd/containerd/v2/pkg/namespaces"
+	"github.com/containerd/containerd/v2/pkg/shim"
+	"github.com/containerd/log"
+)
+
+const (
+	// maxPendingEvents bounds the number of events waiting to be published
+	maxPendingEvents = 1024
+
+	// flushTimeout limits how long shutdown waits for pending events to be published
+	flushTimeout = 10 * time.Second
+)
+
+// eventQueue publishes events in the order they were sent, without blocking senders.
+//
+// Events are published by a single goroutine, so the order of events of every container is preserved.
+// When the publisher stalls and the queue is full, new events are dropped, except for lifecycle events.
+// containerd relies on TaskExit to release waiters, and on the events that precede it to make sense of it,
+// so lifecycle events of a process are kept together and queued regardless of the limit.
+// There are at most a few of them per process, so they are bounded by processes the shim runs.
+type eventQueue struct {
+	mu      sync.Mutex
+	cond    sync.Cond
+	pending []interface{}
+	closed  bool
+	dropped uint64
+
+	// done is closed once all events are published and publisher is closed
+	done chan struct{}
+}
+
+func newEventQueue() *eventQueue {
+	q := &eventQueue{
+		done: make(chan struct{}),
+	}
+	q.cond.L = &q.mu
+
+	return q
+}
+
+// send queues event for publishing.
+func (q *eventQueue) send(event interface{}) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.closed {
+		q.dropped++
+		log.L.WithField("topic", runtime.GetTopic(event)).Warn("dropping event sent after shutdown")
+		return
+	}
+
+	if !isLifecycleEvent(event) && len(q.pending) >= maxPendingEvents {
+		q.dropped++
+		log.L.WithField("topic", runtime.GetTopic(event)).WithField("dropped", q.dropped).Error("event queue is full, dropping event")
+		return
+	}
+
+	q.pending = append(q.pending, event)
+	q.cond.Signal()
+}
+
+// isLifecycleEvent tells whether event reports creation, start, exit or deletion of a process.
+func isLifecycleEvent(event interface{}) bool {
+	switch event.(type) {
+	case *events.TaskCreate, *events.TaskStart, *events.TaskExecAdded, *events.TaskExecStarted, *events.TaskExit, *events.TaskDelete:
+		return true
+	default:
+		return false
+	}
+}
+
+// stats returns the number of events waiting to be published and the number of dropped events.
+func (q *eventQueue) stats() (depth int, dropped uint64) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return len(q.pending), q.dropped
+}
+
+// forward publishes events until the queue is closed and drained, and then closes publisher.
+func (q *eventQueue) forward(ctx context.Context, publisher shim.Publisher) {
+	defer close(q.done)
+
+	ns, _ := namespaces.Namespace(ctx)
+	ctx = namespaces.WithNamespace(context.Background(), ns)
+
+	for {
+		q.mu.Lock()
+		for len(q.pending) == 0 && !q.closed {
+			q.cond.Wait()
+		}
+
+		if len(q.pending) == 0 {
+			q.mu.Unlock()
+			break
+		}
+
+		e := q.pending[0]
+		q.pending[0] = nil
+		q.pending = q.pending[1:]
+		q.mu.Unlock()
+
+		err := publisher.Publish(ctx, runtime.GetTopic(e), e)
+		if err != nil {
+			log.G(ctx).WithError(err).Error("post event")
+		}
+	}
+
+	_ = publisher.Close()
+}
+
+// close stops accepting new events and waits until pending ones are published or timeout expires.
+// It returns false on timeout.
+func (q *eventQueue) close(timeout time.Duration) bool {
+	q.mu.Lock()
+	q.closed = true
+	q.cond.Signal()
+	q.mu.Unlock()
+
+	select {
+	case <-q.done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
@@ -0,0 +1,109 @@
+package containerd
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/containerd/containerd/api/events"
+	eventtypes "github.com/containerd/containerd/v2/core/events"
+	"github.com/stretchr/testify/require"
+)
+
+// blockingPublisher records published events, and blocks publishing until unblocked.
+type blockingPublisher struct {
+	mu        sync.Mutex
+	published []interface{}
+	closed    bool
+	unblock   chan struct{}
+}
+
+func (p *blockingPublisher) Publish(_ context.Context, _ string, event eventtypes.Event) error {
+	<-p.unblock
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.published = append(p.published, event)
+	return nil
+}
+
+func (p *blockingPublisher) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.closed = true
+	return nil
+}
+
+func TestEventQueueOrder(t *testing.T) {
+	q := newEventQueue()
+	publisher := &blockingPublisher{unblock: make(chan struct{})}
+	go q.forward(context.Background(), publisher)
+
+	var sent []interface{}
+	for i := 0; i < 10; i++ {
+		e := &events.TaskStart{ContainerID: "test", Pid: uint32(i)}
+		sent = append(sent, e)
+		q.send(e)
+	}
+
+	close(publisher.unblock)
+	require.True(t, q.close(time.Second))
+
+	require.Equal(t, sent, publisher.published)
+	require.True(t, publisher.closed)
+
+	depth, dropped := q.stats()
+	require.Zero(t, depth)
+	require.Zero(t, dropped)
+}
+
+func TestEventQueueFull(t *testing.T) {
+	q := newEventQueue()
+	publisher := &blockingPublisher{unblock: make(chan struct{})}
+	go q.forward(context.Background(), publisher)
+
+	// Sending must not block while the publisher is stalled
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+
+		for i := 0; i < maxPendingEvents+10; i++ {
+			q.send(&events.TaskPaused{ContainerID: "test"})
+		}
+		q.send(&events.TaskCreate{ContainerID: "test"})
+		q.send(&events.TaskStart{ContainerID: "test"})
+		q.send(&events.TaskExit{ContainerID: "test"})
+		q.send(&events.TaskDelete{ContainerID: "test"})
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("send blocked")
+	}
+
+	// One event may have been taken by the stalled publisher, lifecycle events are queued above the limit
+	depth, dropped := q.stats()
+	require.LessOrEqual(t, depth, maxPendingEvents+4)
+	require.GreaterOrEqual(t, depth+int(dropped), maxPendingEvents+13)
+	require.GreaterOrEqual(t, dropped, uint64(9))
+
+	require.False(t, q.close(10*time.Millisecond))
+
+	close(publisher.unblock)
+	<-q.done
+
+	lifecycle := publisher.published[len(publisher.published)-4:]
+	require.IsType(t, &events.TaskCreate{}, lifecycle[0])
+	require.IsType(t, &events.TaskStart{}, lifecycle[1])
+	require.IsType(t, &events.TaskExit{}, lifecycle[2])
+	require.IsType(t, &events.TaskDelete{}, lifecycle[3])
+	require.True(t, publisher.closed)
+
+	q.send(&events.TaskStart{ContainerID: "test"})
+	_, droppedAfterClose := q.stats()
+	require.Equal(t, dropped+1, droppedAfterClose)
+}
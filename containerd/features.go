@@ -0,0 +1,71 @@
+package containerd
+
+import (
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/opencontainers/runtime-spec/specs-go/features"
+)
+
+const (
+	mountTypeBind  = "bind"
+	mountTypeDevfs = "devfs"
+)
+
+// Capabilities of rund. manager.Info reports them to containerd clients and the service checks specs against them.
+var (
+	// mountTypes are types of mounts that rund mounts, other mounts are skipped
+	mountTypes = []string{mountTypeBind, mountTypeDevfs}
+
+	// mountOptions are options of bind mounts recognized by bindfs
+	mountOptions = []string{"bind", "rbind", "ro", "rw"}
+
+	// hooks are OCI hooks that rund runs, it runs none
+	hooks = []string{}
+
+	// rlimits are process rlimits that rund applies, it applies none
+	rlimits = []string{}
+
+	// optionalRPCs tells which task RPCs that runtimes may leave unimplemented are implemented.
+	// Pause stops process groups of container processes, see pauseScope.
+	optionalRPCs = map[string]bool{
+		"Pause":      true,
+		"Resume":     true,
+		"Pids":       false,
+		"Stats":      false,
+		"Update":     false,
+		"Checkpoint": true,
+	}
+
+	// pauseScope tells what Pause stops. Darwin has no freezer, so processes that left
+	// the process group of a container process, e.g. daemons, are not stopped.
+	pauseScope = "process-group"
+)
+
+// RuntimeFeatures returns the OCI features document of rund, reported by manager.Info and the features command.
+// Capabilities that the document has no fields for are reported as annotations with annotationPrefix,
+// lists are comma separated.
+func RuntimeFeatures() *features.Features {
+	f := &features.Features{
+		OCIVersionMin: "1.0.0",
+		OCIVersionMax: specs.Version,
+		Hooks:         hooks,
+		MountOptions:  mountOptions,
+		Annotations: map[string]string{
+			annotationPrefix + "version":     Version,
+			annotationPrefix + "mount.types": strings.Join(mountTypes, ","),
+			annotationPrefix + "rlimits":     strings.Join(rlimits, ","),
+			annotationPrefix + "annotations": strings.Join(configAnnotations, ","),
+			annotationPrefix + "pause.scope": pauseScope,
+		},
+	}
+
+	for _, rpc := range slices.Sorted(maps.Keys(optionalRPCs)) {
+		f.Annotations[annotationPrefix+"rpc."+strings.ToLower(rpc)] = strconv.FormatBool(optionalRPCs[rpc])
+	}
+
+	return f
+}
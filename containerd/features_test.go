@@ -0,0 +1,97 @@
+package containerd
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	taskAPI "github.com/containerd/containerd/api/runtime/task/v3"
+	"github.com/containerd/containerd/api/types/task"
+	"github.com/containerd/errdefs"
+	"github.com/containerd/typeurl/v2"
+	"github.com/opencontainers/runtime-spec/specs-go/features"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInfoFeatures(t *testing.T) {
+	info, err := NewManager("io.containerd.rund.v2").Info(context.Background(), nil)
+	require.NoError(t, err)
+	require.Equal(t, Version, info.Version.Version)
+
+	v, err := typeurl.UnmarshalAny(info.Features)
+	require.NoError(t, err)
+
+	f, ok := v.(*features.Features)
+	require.True(t, ok, "unexpected features type %T", v)
+	require.Equal(t, mountOptions, f.MountOptions)
+	require.Empty(t, f.Hooks)
+	require.Equal(t, "bind,devfs", f.Annotations["io.rund.mount.types"])
+	require.Equal(t, "io.rund.default-args,io.rund.exec-inherit-env,io.rund.log-file,io.rund.memory-limit,io.rund.pids-limit,io.rund.sockets,io.rund.stop-timeout,io.rund.validation",
+		f.Annotations["io.rund.annotations"])
+	require.Equal(t, "true", f.Annotations["io.rund.rpc.pause"])
+	require.Equal(t, "process-group", f.Annotations["io.rund.pause.scope"])
+	require.Equal(t, "true", f.Annotations["io.rund.rpc.checkpoint"])
+}
+
+func TestOptionalRPCs(t *testing.T) {
+	s := newTestService(t, task.Status_RUNNING)
+	ctx := context.Background()
+
+	calls := map[string]func() error{
+		"Pause": func() error {
+			_, err := s.Pause(ctx, &taskAPI.PauseRequest{ID: "test"})
+			return err
+		},
+		"Resume": func() error {
+			_, err := s.Resume(ctx, &taskAPI.ResumeRequest{ID: "test"})
+			return err
+		},
+		"Pids": func() error {
+			_, err := s.Pids(ctx, &taskAPI.PidsRequest{ID: "test"})
+			return err
+		},
+		"Stats": func() error {
+			_, err := s.Stats(ctx, &taskAPI.StatsRequest{ID: "test"})
+			return err
+		},
+		"Update": func() error {
+			_, err := s.Update(ctx, &taskAPI.UpdateTaskRequest{ID: "test"})
+			return err
+		},
+		"Checkpoint": func() error {
+			_, err := s.Checkpoint(ctx, &taskAPI.CheckpointTaskRequest{ID: "test"})
+			return err
+		},
+	}
+	require.Len(t, calls, len(optionalRPCs))
+
+	for rpc, implemented := range optionalRPCs {
+		err := calls[rpc]()
+		require.Equal(t, implemented, !errdefs.IsNotImplemented(err), "%s: %v", rpc, err)
+	}
+}
+
+func TestProcessMountTypes(t *testing.T) {
+	rootfs := t.TempDir()
+	dir := t.TempDir()
+	file := filepath.Join(dir, "file")
+	require.NoError(t, os.WriteFile(file, nil, 0o644))
+
+	m, err := processMount(rootfs, mountTypeBind, dir, "/mnt/dir", []string{"rbind", "ro"})
+	require.NoError(t, err)
+	require.NotNil(t, m)
+	require.DirExists(t, filepath.Join(rootfs, "mnt", "dir"))
+
+	m, err = processMount(rootfs, mountTypeBind, file, "/mnt/file", nil)
+	require.NoError(t, err)
+	require.Nil(t, m, "bind mounts of files are skipped")
+
+	m, err = processMount(rootfs, mountTypeDevfs, "devfs", "/dev", nil)
+	require.NoError(t, err)
+	require.NotNil(t, m)
+
+	m, err = processMount(rootfs, "tmpfs", "tmpfs", "/tmp", nil)
+	require.NoError(t, err)
+	require.Nil(t, m, "unsupported mount types are skipped")
+}
@@ -0,0 +1,246 @@
+package containerd
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	apievents "github.com/containerd/containerd/api/events"
+	taskAPI "github.com/containerd/containerd/api/runtime/task/v3"
+	"github.com/containerd/containerd/v2/core/events"
+	"github.com/containerd/containerd/v2/pkg/shim"
+	"github.com/containerd/containerd/v2/pkg/shutdown"
+	"github.com/containerd/typeurl/v2"
+	"github.com/darwin-containers/rund/internal/testutil"
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+)
+
+type discardPublisher struct{}
+
+func (discardPublisher) Publish(context.Context, string, events.Event) error {
+	return nil
+}
+
+func (discardPublisher) Close() error {
+	return nil
+}
+
+// recordingPublisher records summaries of published events.
+type recordingPublisher struct {
+	mu     sync.Mutex
+	events []string
+}
+
+func (p *recordingPublisher) Publish(_ context.Context, topic string, event events.Event) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.events = append(p.events, summarizeEvent(topic, event))
+	return nil
+}
+
+func (p *recordingPublisher) Close() error {
+	return nil
+}
+
+func (p *recordingPublisher) recorded() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return append([]string(nil), p.events...)
+}
+
+// summarizeEvent formats topic and fields of event that are stable between runs.
+func summarizeEvent(topic string, event events.Event) string {
+	switch e := event.(type) {
+	case *apievents.TaskCreate:
+		return fmt.Sprintf("%s %s", topic, e.ContainerID)
+	case *apievents.TaskStart:
+		return fmt.Sprintf("%s %s", topic, e.ContainerID)
+	case *apievents.TaskExecAdded:
+		return fmt.Sprintf("%s %s %s", topic, e.ContainerID, e.ExecID)
+	case *apievents.TaskExecStarted:
+		return fmt.Sprintf("%s %s %s", topic, e.ContainerID, e.ExecID)
+	case *apievents.TaskExit:
+		return fmt.Sprintf("%s %s %s %d", topic, e.ContainerID, e.ID, e.ExitStatus)
+	case *apievents.TaskDelete:
+		return fmt.Sprintf("%s %s %d", topic, e.ContainerID, e.ExitStatus)
+	}
+	return topic
+}
+
+func newTaskService(t *testing.T, publisher shim.Publisher) *service {
+	ctx, sd := shutdown.WithShutdown(context.Background())
+	t.Cleanup(sd.Shutdown)
+
+	s, err := NewTaskService(ctx, publisher, sd)
+	require.NoError(t, err)
+
+	return s.(*service)
+}
+
+// testProcess returns a process spec that runs args as root in the rootfs root.
+func testProcess(args ...string) *specs.Process {
+	return &specs.Process{
+		Args: args,
+		Env:  []string{"PATH=/usr/bin:/bin"},
+		Cwd:  "/",
+	}
+}
+
+// testHarness drives the task service in process, records published events and captures process output.
+// Methods fail the test on error, call service directly to check errors.
+type testHarness struct {
+	t         *testing.T
+	ctx       context.Context
+	service   *service
+	publisher *recordingPublisher
+	dir       string
+
+	// outputs maps "id/execID" to captured stdout
+	outputs map[string]*capturedOutput
+}
+
+type capturedOutput struct {
+	buf  bytes.Buffer
+	done chan struct{}
+}
+
+func newTestHarness(t *testing.T) *testHarness {
+	publisher := &recordingPublisher{}
+
+	return &testHarness{
+		t:         t,
+		ctx:       context.Background(),
+		service:   newTaskService(t, publisher),
+		publisher: publisher,
+		dir:       t.TempDir(),
+		outputs:   make(map[string]*capturedOutput),
+	}
+}
+
+// stdout creates a fifo that captures stdout of the process.
+func (h *testHarness) stdout(id, execID string) string {
+	path := filepath.Join(h.dir, fmt.Sprintf("%s-%s.stdout", id, execID))
+	require.NoError(h.t, unix.Mkfifo(path, 0o600))
+
+	output := &capturedOutput{done: make(chan struct{})}
+	h.outputs[id+"/"+execID] = output
+
+	go func() {
+		defer close(output.done)
+
+		f, err := os.OpenFile(path, os.O_RDONLY, 0)
+		if err != nil {
+			return
+		}
+		defer f.Close()
+
+		_, _ = io.Copy(&output.buf, f)
+	}()
+
+	h.t.Cleanup(func() {
+		// Unblocks the reader if the process has never opened its stdout
+		if f, err := os.OpenFile(path, os.O_WRONLY|syscall.O_NONBLOCK, 0); err == nil {
+			_ = f.Close()
+		}
+	})
+
+	return path
+}
+
+// create creates container from spec, rootfs is created by testutil.NewBundle.
+func (h *testHarness) create(id string, spec *specs.Spec) {
+	_, err := h.service.Create(h.ctx, &taskAPI.CreateTaskRequest{
+		ID:     id,
+		Bundle: testutil.NewBundle(h.t, spec),
+		Stdout: h.stdout(id, ""),
+	})
+	require.NoError(h.t, err)
+}
+
+func (h *testHarness) exec(id, execID string, process *specs.Process) {
+	spec, err := typeurl.MarshalAnyToProto(process)
+	require.NoError(h.t, err)
+
+	_, err = h.service.Exec(h.ctx, &taskAPI.ExecProcessRequest{
+		ID:     id,
+		ExecID: execID,
+		Spec:   spec,
+		Stdout: h.stdout(id, execID),
+	})
+	require.NoError(h.t, err)
+}
+
+func (h *testHarness) start(id, execID string) uint32 {
+	resp, err := h.service.Start(h.ctx, &taskAPI.StartRequest{ID: id, ExecID: execID})
+	require.NoError(h.t, err)
+
+	return resp.Pid
+}
+
+func (h *testHarness) kill(id, execID string, signal syscall.Signal) {
+	_, err := h.service.Kill(h.ctx, &taskAPI.KillRequest{ID: id, ExecID: execID, Signal: uint32(signal)})
+	require.NoError(h.t, err)
+}
+
+func (h *testHarness) wait(id, execID string) *taskAPI.WaitResponse {
+	resp, err := h.service.Wait(h.ctx, &taskAPI.WaitRequest{ID: id, ExecID: execID})
+	require.NoError(h.t, err)
+
+	return resp
+}
+
+func (h *testHarness) state(id, execID string) *taskAPI.StateResponse {
+	resp, err := h.service.State(h.ctx, &taskAPI.StateRequest{ID: id, ExecID: execID})
+	require.NoError(h.t, err)
+
+	return resp
+}
+
+func (h *testHarness) delete(id, execID string) *taskAPI.DeleteResponse {
+	resp, err := h.service.Delete(h.ctx, &taskAPI.DeleteRequest{ID: id, ExecID: execID})
+	require.NoError(h.t, err)
+
+	return resp
+}
+
+// run starts the process, waits for it and returns its output and exit status.
+func (h *testHarness) run(id, execID string) (string, uint32) {
+	h.start(id, execID)
+	status := h.wait(id, execID).ExitStatus
+
+	return h.output(id, execID), status
+}
+
+// output waits until the process closes its stdout and returns captured output.
+func (h *testHarness) output(id, execID string) string {
+	output := h.outputs[id+"/"+execID]
+	require.NotNil(h.t, output, "stdout of %s/%s is not captured", id, execID)
+
+	select {
+	case <-output.done:
+	case <-time.After(5 * time.Second):
+		h.t.Fatalf("stdout of %s/%s is not closed", id, execID)
+	}
+
+	return output.buf.String()
+}
+
+// requireEvents waits for expected number of events and checks them.
+func (h *testHarness) requireEvents(expected ...string) {
+	require.Eventually(h.t, func() bool {
+		return len(h.publisher.recorded()) >= len(expected)
+	}, 5*time.Second, 10*time.Millisecond)
+
+	require.Equal(h.t, expected, h.publisher.recorded())
+}
@@ -0,0 +1,10 @@
+package containerd
+
+// processLimits are resource limits of container processes, zero limits are unlimited.
+// Limits are inherited by the started process, see inherit, or set once it is started, see apply.
+type processLimits struct {
+	// memory limits memory of processes that rund starts in bytes, see limitMemory
+	memory uint64
+	// pids limits the number of processes of the process user, it is inherited by children
+	pids uint64
+}
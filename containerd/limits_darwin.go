@@ -0,0 +1,65 @@
+package containerd
+
+import (
+	"fmt"
+	"math"
+	"sync"
+
+	"golang.org/x/sys/unix"
+)
+
+// memorystatusCmdSetJetsamTaskLimit is MEMORYSTATUS_CMD_SET_JETSAM_TASK_LIMIT from sys/kern_memorystatus.h.
+// launchd limits memory of jobs with it.
+const memorystatusCmdSetJetsamTaskLimit = 6
+
+// forkMu serializes starts of processes, as pids limit is set on the shim while a process with the limit is forked.
+// Processes without limit are started under it as well, so they don't inherit the limit of another process.
+var forkMu sync.Mutex
+
+// inherit wraps start, so the started process inherits pids limit from the shim.
+// Darwin can't set limits of other processes, so the limit is set on the shim itself while the process is forked.
+// The shim runs as root, that isn't subject to the limit and may restore it.
+func (l processLimits) inherit(start func() error) func() error {
+	return func() (err error) {
+		forkMu.Lock()
+		defer forkMu.Unlock()
+
+		if l.pids == 0 {
+			return start()
+		}
+
+		var old unix.Rlimit
+		if err := unix.Getrlimit(unix.RLIMIT_NPROC, &old); err != nil {
+			return err
+		}
+
+		if err := unix.Setrlimit(unix.RLIMIT_NPROC, &unix.Rlimit{Cur: l.pids, Max: l.pids}); err != nil {
+			return err
+		}
+
+		defer func() {
+			if restoreErr := unix.Setrlimit(unix.RLIMIT_NPROC, &old); restoreErr != nil && err == nil {
+				err = fmt.Errorf("failed to restore pids limit of the shim: %w", restoreErr)
+			}
+		}()
+
+		return start()
+	}
+}
+
+// apply sets jetsam limit of the started process, so it is killed once its memory footprint exceeds memory limit.
+// Darwin doesn't enforce RLIMIT_AS. The jetsam limit is in megabytes, so the limit is rounded up.
+func (l processLimits) apply(pid int) error {
+	if l.memory == 0 {
+		return nil
+	}
+
+	megabytes := min((l.memory+1<<20-1)>>20, math.MaxInt32)
+
+	_, _, errno := unix.Syscall6(unix.SYS_MEMORYSTATUS_CONTROL, memorystatusCmdSetJetsamTaskLimit, uintptr(pid), uintptr(megabytes), 0, 0, 0)
+	if errno != 0 {
+		return errno
+	}
+
+	return nil
+}
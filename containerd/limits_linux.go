@@ -0,0 +1,26 @@
+package containerd
+
+import "golang.org/x/sys/unix"
+
+// inherit returns start, all limits are set with prlimit once the process is started.
+// Unlike on Darwin, the limits are not in place before the process runs: it may fork or allocate past them
+// until apply is done. Setting them on the shim while forking would limit address space of the shim itself,
+// and Go can't set limits of a child between fork and exec. Linux builds are used for development and tests.
+func (l processLimits) inherit(start func() error) func() error {
+	return start
+}
+
+// apply limits address space and the number of processes of the started process.
+func (l processLimits) apply(pid int) error {
+	for resource, limit := range map[int]uint64{unix.RLIMIT_AS: l.memory, unix.RLIMIT_NPROC: l.pids} {
+		if limit == 0 {
+			continue
+		}
+
+		if err := unix.Prlimit(pid, resource, &unix.Rlimit{Cur: limit, Max: limit}, nil); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
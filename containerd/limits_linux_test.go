@@ -0,0 +1,38 @@
+package containerd
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"syscall"
+	"testing"
+
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProcessLimits(t *testing.T) {
+	h := newTestHarness(t)
+
+	h.create("test", &specs.Spec{
+		Process:     testProcess("sleep", "60"),
+		Annotations: map[string]string{memoryLimitAnnotation: "1073741824", pidsLimitAnnotation: "1000"},
+	})
+	pid := h.start("test", "")
+
+	h.exec("test", "exec", testProcess("sleep", "60"))
+	execPid := h.start("test", "exec")
+
+	for _, pid := range []uint32{pid, execPid} {
+		limits, err := os.ReadFile(fmt.Sprintf("/proc/%d/limits", pid))
+		require.NoError(t, err)
+		require.Regexp(t, regexp.MustCompile(`Max address space\s+1073741824\s+1073741824\s+bytes`), string(limits))
+		require.Regexp(t, regexp.MustCompile(`Max processes\s+1000\s+1000\s+processes`), string(limits))
+	}
+
+	h.kill("test", "exec", syscall.SIGKILL)
+	h.wait("test", "exec")
+	h.kill("test", "", syscall.SIGKILL)
+	h.wait("test", "")
+	h.delete("test", "")
+}
@@ -0,0 +1,208 @@
+package containerd
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	taskAPI "github.com/containerd/containerd/api/runtime/task/v3"
+	"github.com/containerd/log"
+	"github.com/opencontainers/runtime-spec/specs-go"
+)
+
+const (
+	// idField and execIDField are log fields with container and exec ids of the RPC
+	idField     = "id"
+	execIDField = "exec_id"
+
+	// logFileName is the log file of the container in its bundle, see logFileAnnotation
+	logFileName = "rund.log"
+
+	redacted = "<redacted>"
+)
+
+// rpcLogLevels are levels of RPCs that are not logged at info level.
+// containerd polls these RPCs or keeps them pending, logging them at info level floods the log.
+var rpcLogLevels = map[string]log.Level{
+	"State":     log.DebugLevel,
+	"Wait":      log.DebugLevel,
+	"Connect":   log.DebugLevel,
+	"Pids":      log.DebugLevel,
+	"Stats":     log.DebugLevel,
+	"ResizePty": log.DebugLevel,
+}
+
+// plainEnv lists environment variables whose values are logged as is, values of the rest are redacted.
+var plainEnv = map[string]bool{
+	"PATH":     true,
+	"HOME":     true,
+	"HOSTNAME": true,
+	"TERM":     true,
+	"LANG":     true,
+	"USER":     true,
+	"SHELL":    true,
+	"PWD":      true,
+}
+
+// logRPC logs request of RPC method and returns context whose logger has container and exec ids as fields.
+// done logs the result of the RPC, failed RPCs are logged at least at info level.
+func logRPC(ctx context.Context, method, id, execID string, request interface{}) (_ context.Context, done func(error)) {
+	entry := log.G(ctx)
+	if id != "" {
+		entry = entry.WithField(idField, id)
+	}
+	if execID != "" {
+		entry = entry.WithField(execIDField, execID)
+	}
+	ctx = log.WithLogger(ctx, entry)
+
+	level, ok := rpcLogLevels[method]
+	if !ok {
+		level = log.InfoLevel
+	}
+
+	name := strings.ToUpper(method)
+
+	entry.WithField("request", redactRequest(request)).Log(level, name)
+
+	return ctx, func(err error) {
+		if err != nil && level > log.InfoLevel {
+			level = log.InfoLevel
+		}
+		entry.WithError(err).Log(level, name+"_DONE")
+	}
+}
+
+// redactRequest returns request without fields that may contain secrets.
+func redactRequest(request interface{}) interface{} {
+	switch r := request.(type) {
+	case *taskAPI.ExecProcessRequest:
+		// Spec is logged separately by Exec with environment redacted
+		return &taskAPI.ExecProcessRequest{
+			ID:       r.ID,
+			ExecID:   r.ExecID,
+			Terminal: r.Terminal,
+			Stdin:    r.Stdin,
+			Stdout:   r.Stdout,
+			Stderr:   r.Stderr,
+		}
+	}
+	return request
+}
+
+// redactProcess returns a copy of process spec with values of environment variables redacted.
+func redactProcess(process *specs.Process) *specs.Process {
+	if process == nil {
+		return nil
+	}
+
+	redactedProcess := *process
+	redactedProcess.Env = make([]string, 0, len(process.Env))
+	for _, kv := range process.Env {
+		name, _, _ := strings.Cut(kv, "=")
+		if !plainEnv[name] {
+			kv = name + "=" + redacted
+		}
+		redactedProcess.Env = append(redactedProcess.Env, kv)
+	}
+
+	return &redactedProcess
+}
+
+// containerLogs is a log hook that mirrors log entries with container id field to log files of containers.
+type containerLogs struct {
+	mu    sync.Mutex
+	files map[string]*os.File
+}
+
+func newContainerLogs() *containerLogs {
+	return &containerLogs{
+		files: make(map[string]*os.File),
+	}
+}
+
+var (
+	hookedLogs     *containerLogs
+	hookedLogsOnce sync.Once
+)
+
+// hookContainerLogs returns container logs whose hook is added to the standard logger.
+// The hook is added once per shim process, task services share it instead of stacking hooks.
+func hookContainerLogs() *containerLogs {
+	hookedLogsOnce.Do(func() {
+		hookedLogs = newContainerLogs()
+		log.L.Logger.AddHook(hookedLogs)
+	})
+
+	return hookedLogs
+}
+
+// open creates the log file of the container in its bundle if enabled.
+func (l *containerLogs) open(id, bundle string, enabled bool) error {
+	if !enabled {
+		return nil
+	}
+
+	f, err := os.OpenFile(filepath.Join(bundle, logFileName), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if old := l.files[id]; old != nil {
+		_ = old.Close()
+	}
+	l.files[id] = f
+
+	return nil
+}
+
+// close stops mirroring log entries of the container.
+func (l *containerLogs) close(id string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if f := l.files[id]; f != nil {
+		_ = f.Close()
+		delete(l.files, id)
+	}
+}
+
+func (l *containerLogs) Levels() []log.Level {
+	return []log.Level{
+		log.PanicLevel,
+		log.FatalLevel,
+		log.ErrorLevel,
+		log.WarnLevel,
+		log.InfoLevel,
+		log.DebugLevel,
+		log.TraceLevel,
+	}
+}
+
+func (l *containerLogs) Fire(entry *log.Entry) error {
+	id, ok := entry.Data[idField].(string)
+	if !ok {
+		return nil
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	f := l.files[id]
+	if f == nil {
+		return nil
+	}
+
+	line, err := entry.Logger.Formatter.Format(entry)
+	if err != nil {
+		return err
+	}
+
+	_, err = f.Write(line)
+	return err
+}
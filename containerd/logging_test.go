@@ -0,0 +1,75 @@
+package containerd
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	taskAPI "github.com/containerd/containerd/api/runtime/task/v3"
+	"github.com/containerd/log"
+	"github.com/containerd/typeurl/v2"
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRedactProcess(t *testing.T) {
+	process := &specs.Process{
+		Args: []string{"env"},
+		Env:  []string{"PATH=/usr/bin", "TOKEN=secret", "EMPTY", "PASSWORD="},
+	}
+
+	redactedProcess := redactProcess(process)
+	require.Equal(t, []string{"PATH=/usr/bin", "TOKEN=<redacted>", "EMPTY=<redacted>", "PASSWORD=<redacted>"}, redactedProcess.Env)
+	require.Equal(t, process.Args, redactedProcess.Args)
+
+	// Original spec is left intact
+	require.Equal(t, []string{"PATH=/usr/bin", "TOKEN=secret", "EMPTY", "PASSWORD="}, process.Env)
+}
+
+func TestRedactRequest(t *testing.T) {
+	spec, err := typeurl.MarshalAnyToProto(&specs.Process{Env: []string{"TOKEN=secret"}})
+	require.NoError(t, err)
+
+	request := &taskAPI.ExecProcessRequest{ID: "test", ExecID: "exec", Stdout: "stdout", Spec: spec}
+
+	redactedRequest := redactRequest(request).(*taskAPI.ExecProcessRequest)
+	require.Nil(t, redactedRequest.Spec)
+	require.Equal(t, "exec", redactedRequest.ExecID)
+	require.Equal(t, "stdout", redactedRequest.Stdout)
+	require.NotNil(t, request.Spec)
+
+	state := &taskAPI.StateRequest{ID: "test"}
+	require.Same(t, state, redactRequest(state))
+}
+
+func TestContainerLogs(t *testing.T) {
+	logs := hookContainerLogs()
+
+	bundle := t.TempDir()
+	require.NoError(t, logs.open("test", bundle, true))
+
+	log.L.WithField(idField, "test").Warn("mirrored entry")
+	log.L.WithField(idField, "other").Warn("other entry")
+	logs.close("test")
+	log.L.WithField(idField, "test").Warn("entry after close")
+
+	content, err := os.ReadFile(filepath.Join(bundle, logFileName))
+	require.NoError(t, err)
+	require.Contains(t, string(content), "mirrored entry")
+	require.NotContains(t, string(content), "other entry")
+	require.NotContains(t, string(content), "entry after close")
+
+	// No file is created unless asked for
+	require.NoError(t, logs.open("disabled", t.TempDir(), false))
+	require.NotContains(t, logs.files, "disabled")
+}
+
+func TestContainerLogsHookedOnce(t *testing.T) {
+	hookContainerLogs()
+	hooks := len(log.L.Logger.Hooks[log.InfoLevel])
+
+	newTaskService(t, &recordingPublisher{})
+	newTaskService(t, &recordingPublisher{})
+
+	require.Len(t, log.L.Logger.Hooks[log.InfoLevel], hooks)
+}
@@ -3,29 +3,83 @@ package containerd
 import (
 	"context"
 	"errors"
+	"fmt"
 	"io"
 	"os"
 	"os/exec"
+	"slices"
+	"strconv"
+	"strings"
 	"sync"
 	"syscall"
 	"time"
 
 	"github.com/containerd/containerd/api/types/task"
+	"github.com/containerd/errdefs"
+	"github.com/containerd/errdefs/pkg/errgrpc"
 	"github.com/creack/pty"
 	"github.com/opencontainers/runtime-spec/specs-go"
-	"golang.org/x/sys/unix"
 )
 
+// waitProcessGroupEnv allows to opt out of waiting for the whole process group of an exec process
+// by setting it to a false value in exec environment. The variable is not passed to the process.
+// Exec specs carry no annotations, so this is the only per-exec setting and is documented in README.adoc.
+const waitProcessGroupEnv = "RUND_WAIT_PROCESS_GROUP"
+
+// consoleDrainTimeout bounds how long exit of a process with terminal waits for the rest of its output to be copied,
+// descendants that still hold the terminal would keep the copy going
+const consoleDrainTimeout = time.Second
+
 type managedProcess struct {
-	spec       *specs.Process
-	io         stdio
-	console    *os.File
-	mu         sync.Mutex
-	cmd        *exec.Cmd
-	waitblock  chan struct{}
-	status     task.Status
-	exitStatus uint32
-	exitedAt   time.Time
+	spec      *specs.Process
+	io        stdio
+	console   *os.File
+	mu        sync.Mutex
+	cmd       *exec.Cmd
+	waitblock chan struct{}
+	state     processState
+	limits    processLimits
+
+	// consoleDrained is closed once output of the console has been copied to stdout, nil without terminal or stdout
+	consoleDrained chan struct{}
+
+	// waitProcessGroup tells whether process exit is reported only after its whole process group is gone
+	waitProcessGroup bool
+}
+
+func newManagedProcess(spec *specs.Process, waitProcessGroup bool, limits processLimits) *managedProcess {
+	p := &managedProcess{
+		spec:             spec,
+		waitblock:        make(chan struct{}),
+		limits:           limits,
+		waitProcessGroup: waitProcessGroup,
+	}
+	p.state.status = task.Status_CREATED
+
+	return p
+}
+
+// takeWaitProcessGroup removes waitProcessGroupEnv from spec environment
+// and returns whether process group should be waited for.
+func takeWaitProcessGroup(spec *specs.Process) (bool, error) {
+	result := true
+
+	env := spec.Env[:0]
+	for _, kv := range spec.Env {
+		value, found := strings.CutPrefix(kv, waitProcessGroupEnv+"=")
+		if !found {
+			env = append(env, kv)
+			continue
+		}
+
+		var err error
+		if result, err = strconv.ParseBool(value); err != nil {
+			return false, fmt.Errorf("invalid %s value %q: %w", waitProcessGroupEnv, value, err)
+		}
+	}
+	spec.Env = env
+
+	return result, nil
 }
 
 func (p *managedProcess) getConsoleL() *os.File {
@@ -38,39 +92,40 @@ func (p *managedProcess) getConsoleL() *os.File {
 func (p *managedProcess) destroy() error {
 	var errs []error
 
-	// TODO: Do we care about error?
+	// Process that has never been started or has already exited is not signaled
 	_ = p.kill(syscall.SIGKILL)
 
 	if err := p.io.Close(); err != nil {
 		errs = append(errs, err)
 	}
 
-	if p.console != nil {
-		if err := p.console.Close(); err != nil {
+	if console := p.getConsoleL(); console != nil {
+		if err := console.Close(); err != nil {
 			errs = append(errs, err)
 		}
 	}
 
-	if p.status != task.Status_STOPPED {
-		p.status = task.Status_STOPPED
-		p.exitedAt = time.Now()
-		p.exitStatus = uint32(syscall.SIGKILL)
+	// Process that has never been started won't be reaped, so its exit is recorded here.
+	// Exit of a started process is recorded once it is reaped.
+	if p.state.get().status == task.Status_CREATED {
+		p.state.exit(128+uint32(syscall.SIGKILL), time.Now(), func(processStatus) {
+			close(p.waitblock)
+		})
 	}
 
 	return errors.Join(errs...)
 }
 
+// kill sends signal to the process group of the process while it is running or paused.
+// Process that has exited is not signaled, as its pid may already be reused.
 func (p *managedProcess) kill(signal syscall.Signal) error {
-	if p.cmd != nil {
-		if process := p.cmd.Process; p != nil {
-			return unix.Kill(-process.Pid, signal)
-		}
-	}
-
-	return nil
+	return p.state.signal(signal)
 }
 
-func (p *managedProcess) setup(ctx context.Context, rootfs string, stdin string, stdout string, stderr string) error {
+// setup prepares the command of the process, processes without args run defaultArgs.
+// The executable is resolved inside rootfs against PATH of the process, missing working directory is created.
+// Environment of the process gets defaults, see processEnv.
+func (p *managedProcess) setup(ctx context.Context, rootfs string, defaultArgs []string, stdin string, stdout string, stderr string) error {
 	var err error
 
 	p.io, err = setupIO(ctx, stdin, stdout, stderr)
@@ -78,27 +133,63 @@ func (p *managedProcess) setup(ctx context.Context, rootfs string, stdin string,
 		return err
 	}
 
-	if len(p.spec.Args) <= 0 {
-		// TODO: How to handle this properly?
-		p.spec.Args = []string{"/bin/sh"}
-		// return fmt.Errorf("args must not be empty")
+	if len(p.spec.Args) == 0 {
+		if len(defaultArgs) == 0 {
+			return errgrpc.ToGRPCf(errdefs.ErrInvalidArgument, "process args must not be empty, "+
+				"set default args with %s annotation or DefaultArgs option", defaultArgsAnnotation)
+		}
+		p.spec.Args = slices.Clone(defaultArgs)
+	}
+
+	u, err := resolveUser(rootfs, p.spec.User)
+	if err != nil {
+		return err
 	}
 
-	p.cmd = exec.Command(p.spec.Args[0])
+	if err := prepareWorkingDir(rootfs, p.spec.Cwd, u); err != nil {
+		return err
+	}
+
+	env := processEnv(p.spec.Env, u, p.spec.Terminal)
+
+	// exec.Command would look the executable up on the host, it has to be found inside rootfs instead
+	executable, err := lookPath(rootfs, p.spec.Args[0], p.spec.Cwd, env)
+	if err != nil {
+		return err
+	}
+
+	p.cmd = exec.Command(executable)
 	p.cmd.Args = p.spec.Args
 	p.cmd.Dir = p.spec.Cwd
-	p.cmd.Env = p.spec.Env
+	p.cmd.Env = env
+	// Every process is a leader of its own session and process group, with or without terminal.
+	// That way kill(-pid) and the reaper cover all of its children.
 	p.cmd.SysProcAttr = &syscall.SysProcAttr{
 		Chroot: rootfs,
 		Credential: &syscall.Credential{
-			Uid: p.spec.User.UID,
-			Gid: p.spec.User.GID,
+			Uid:    u.uid,
+			Gid:    u.gid,
+			Groups: u.groups,
 		},
+		Setsid: true,
 	}
 
 	return nil
 }
 
+// drainOutput waits until output that the exited process has left in its console is copied to stdout,
+// so that closing stdio doesn't cut it off. Output of processes without terminal goes to the fifos directly.
+func (p *managedProcess) drainOutput() {
+	if p.consoleDrained == nil {
+		return
+	}
+
+	select {
+	case <-p.consoleDrained:
+	case <-time.After(consoleDrainTimeout):
+	}
+}
+
 func (p *managedProcess) start() (err error) {
 	if p.spec.Terminal {
 		// TODO: I'd like to use containerd/console package instead
@@ -111,26 +202,57 @@ func (p *managedProcess) start() (err error) {
 			}
 		}
 
-		p.console, err = pty.StartWithSize(p.cmd, consoleSize)
+		var console *os.File
+		err = startCommand(p.cmd, p.limits.inherit(func() (err error) {
+			console, err = pty.StartWithSize(p.cmd, consoleSize)
+			return err
+		}))
 		if err != nil {
 			return err
 		}
 
-		go io.Copy(p.console, p.io.stdin)
-		go io.Copy(p.io.stdout, p.console)
+		p.mu.Lock()
+		p.console = console
+		p.mu.Unlock()
+
+		if p.io.stdin != nil {
+			go io.Copy(console, p.io.stdin)
+		}
+		if p.io.stdout != nil {
+			p.consoleDrained = make(chan struct{})
+			go func() {
+				defer close(p.consoleDrained)
+				_, _ = io.Copy(p.io.stdout, console)
+			}()
+		}
 	} else {
-		p.cmd.SysProcAttr.Setpgid = true
 		p.cmd.Stdin = p.io.stdin
-		p.cmd.Stdout = p.io.stdout
-		p.cmd.Stderr = p.io.stderr
 
-		err = p.cmd.Start()
+		var files []*os.File
+		if files, err = p.io.attachOutput(p.cmd); err != nil {
+			return err
+		}
+		// The process has its own copies of the descriptors once it has started
+		defer func() {
+			for _, f := range files {
+				_ = f.Close()
+			}
+		}()
+
+		err = startCommand(p.cmd, p.limits.inherit(p.cmd.Start))
 		if err != nil {
 			return err
 		}
 	}
 
-	p.status = task.Status_RUNNING
+	if err = p.limits.apply(p.cmd.Process.Pid); err != nil {
+		_ = p.cmd.Process.Kill()
+		_, _ = waitProcess(p.cmd.Process)
+		if console := p.getConsoleL(); console != nil {
+			_ = console.Close()
+		}
+		return fmt.Errorf("failed to limit process: %w", err)
+	}
 
-	return nil
+	return p.state.start(p.cmd.Process.Pid)
 }
@@ -0,0 +1,88 @@
+package containerd
+
+import (
+	"context"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+)
+
+func startProcessTree(t *testing.T, terminal bool) *managedProcess {
+	if os.Geteuid() != 0 {
+		t.Skip("requires root")
+	}
+
+	p := newManagedProcess(&specs.Process{
+		Terminal: terminal,
+		Args:     []string{"/bin/sh", "-c", "sleep 60 & /bin/sh -c 'sleep 60 & wait' & wait"},
+		Env:      []string{"PATH=/usr/bin:/bin"},
+		Cwd:      "/",
+	}, true, processLimits{})
+	t.Cleanup(func() {
+		_ = p.destroy()
+	})
+
+	require.NoError(t, p.setup(context.Background(), "/", nil, "", "", ""))
+	require.NoError(t, p.start())
+
+	pid := p.cmd.Process.Pid
+	require.Eventually(t, func() bool {
+		pids, err := processGroupMembers(pid)
+		return err == nil && len(pids) == 4
+	}, 5*time.Second, 10*time.Millisecond)
+
+	return p
+}
+
+func testProcessTree(t *testing.T, terminal bool) {
+	p := startProcessTree(t, terminal)
+	pid := p.cmd.Process.Pid
+
+	sid, err := unix.Getsid(pid)
+	require.NoError(t, err)
+	require.Equal(t, pid, sid)
+
+	pgid, err := unix.Getpgid(pid)
+	require.NoError(t, err)
+	require.Equal(t, pid, pgid)
+
+	require.NoError(t, p.kill(syscall.SIGKILL))
+
+	w, err := wait(p.cmd.Process)
+	require.NoError(t, err)
+	require.Equal(t, int(syscall.SIGKILL), int(w.Sys().(syscall.WaitStatus)))
+
+	pids, err := processGroupMembers(pid)
+	require.NoError(t, err)
+	require.Empty(t, pids)
+}
+
+func TestProcessTreeKill(t *testing.T) {
+	testProcessTree(t, false)
+}
+
+func TestProcessTreeKillTerminal(t *testing.T) {
+	testProcessTree(t, true)
+}
+
+func TestProcessTreeWait(t *testing.T) {
+	for _, terminal := range []bool{false, true} {
+		p := startProcessTree(t, terminal)
+		pid := p.cmd.Process.Pid
+
+		// Only the leader is killed, the reaper must take care of the rest of the group
+		require.NoError(t, p.cmd.Process.Signal(syscall.SIGKILL))
+
+		_, err := wait(p.cmd.Process)
+		require.NoError(t, err)
+
+		pids, err := processGroupMembers(pid)
+		require.NoError(t, err)
+		require.Empty(t, pids)
+	}
+}
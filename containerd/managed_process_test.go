@@ -0,0 +1,25 @@
+package containerd
+
+import (
+	"testing"
+
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTakeWaitProcessGroup(t *testing.T) {
+	spec := &specs.Process{Env: []string{"A=1", waitProcessGroupEnv + "=false", "B=2"}}
+	waitProcessGroup, err := takeWaitProcessGroup(spec)
+	require.NoError(t, err)
+	require.False(t, waitProcessGroup)
+	require.Equal(t, []string{"A=1", "B=2"}, spec.Env)
+
+	spec = &specs.Process{Env: []string{"A=1"}}
+	waitProcessGroup, err = takeWaitProcessGroup(spec)
+	require.NoError(t, err)
+	require.True(t, waitProcessGroup)
+
+	spec = &specs.Process{Env: []string{waitProcessGroupEnv + "=maybe"}}
+	_, err = takeWaitProcessGroup(spec)
+	require.Error(t, err)
+}
@@ -16,6 +16,7 @@ import (
 	"github.com/containerd/containerd/v2/pkg/oci"
 	"github.com/containerd/containerd/v2/pkg/shim"
 	"github.com/containerd/log"
+	"github.com/containerd/typeurl/v2"
 )
 
 func NewManager(name string) shim.Manager {
@@ -33,6 +34,13 @@ func (m *manager) Info(_ context.Context, _ io.Reader) (*types.RuntimeInfo, erro
 			Version: Version,
 		},
 	}
+
+	features, err := typeurl.MarshalAnyToProto(RuntimeFeatures())
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal features: %w", err)
+	}
+	info.Features = features
+
 	return info, nil
 }
 
@@ -84,6 +92,40 @@ func (*manager) Start(ctx context.Context, id string, opts shim.StartOpts) (para
 
 	cmd.ExtraFiles = append(cmd.ExtraFiles, f)
 
+	if opts.Debug {
+		// The shim started with -debug serves pprof, containers dump and metrics on the socket passed as fd 4
+		debugAddress, err := shim.SocketAddress(ctx, opts.Address, id, true)
+		if err != nil {
+			return params, err
+		}
+
+		debugSocket, err := shim.NewSocket(debugAddress)
+		if err != nil {
+			if !shim.SocketEaddrinuse(err) {
+				return params, fmt.Errorf("create new shim debug socket: %w", err)
+			}
+			if err := shim.RemoveSocket(debugAddress); err != nil {
+				return params, fmt.Errorf("remove pre-existing debug socket: %w", err)
+			}
+			if debugSocket, err = shim.NewSocket(debugAddress); err != nil {
+				return params, fmt.Errorf("try create new shim debug socket 2x: %w", err)
+			}
+		}
+		defer func() {
+			if retErr != nil {
+				_ = debugSocket.Close()
+				_ = shim.RemoveSocket(debugAddress)
+			}
+		}()
+
+		debugFile, err := debugSocket.File()
+		if err != nil {
+			return params, err
+		}
+
+		cmd.ExtraFiles = append(cmd.ExtraFiles, debugFile)
+	}
+
 	if err := cmd.Start(); err != nil {
 		_ = f.Close()
 		return params, err
@@ -112,7 +154,8 @@ func (*manager) Stop(ctx context.Context, id string) (shim.StopStatus, error) {
 
 	spec, err := oci.ReadSpec(path.Join(bundlePath, oci.ConfigFilename))
 	if err == nil {
-		if err = mount.UnmountRecursive(spec.Root.Path, unmountFlags); err != nil {
+		// Options of the container are not available here, rootfs is unmounted with the default flags
+		if err = mount.UnmountRecursive(spec.Root.Path, defaultOptions().unmountFlags()); err != nil {
 			log.G(ctx).WithError(err).Warn("failed to cleanup rootfs mount")
 		}
 	}
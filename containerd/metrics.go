@@ -0,0 +1,95 @@
+package containerd
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// metrics accumulates shim metrics that can't be derived from the current state of the service.
+type metrics struct {
+	mount   latency
+	unmount latency
+
+	// proxyConnections counts connections of forwarded sockets proxied for all containers, including deleted ones
+	proxyConnections atomic.Uint64
+}
+
+// latency accumulates durations of an operation.
+type latency struct {
+	mu    sync.Mutex
+	count uint64
+	sum   time.Duration
+}
+
+func (l *latency) observe(d time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.count++
+	l.sum += d
+}
+
+func (l *latency) get() (count uint64, sum time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.count, l.sum
+}
+
+// proxyStats counts connections of forwarded sockets proxied for a container.
+type proxyStats struct {
+	active atomic.Int64
+	total  atomic.Uint64
+}
+
+// writeMetrics writes metrics of the service in Prometheus text format.
+func (s *service) writeMetrics(w io.Writer) error {
+	var containers, execs, activeProxyConnections int64
+	for _, c := range s.listContainers() {
+		containers++
+		activeProxyConnections += c.proxy.active.Load()
+
+		c.mu.Lock()
+		execs += int64(len(c.auxiliary))
+		c.mu.Unlock()
+	}
+
+	depth, dropped := s.events.stats()
+	mounts, mountTime := s.metrics.mount.get()
+	unmounts, unmountTime := s.metrics.unmount.get()
+
+	pw := &metricsWriter{w: w}
+	pw.metric("rund_containers", "gauge", "Number of containers.", containers)
+	pw.metric("rund_execs", "gauge", "Number of exec processes.", execs)
+	pw.metric("rund_event_queue_depth", "gauge", "Number of events waiting to be published.", depth)
+	pw.metric("rund_events_dropped_total", "counter", "Number of events dropped because the queue was full or closed.", dropped)
+	pw.metric("rund_proxy_connections", "gauge", "Number of open connections of forwarded sockets.", activeProxyConnections)
+	pw.metric("rund_proxy_connections_total", "counter", "Number of proxied connections of forwarded sockets.", s.metrics.proxyConnections.Load())
+	pw.summary("rund_mount_duration_seconds", "Time spent mounting container rootfs and mounts.", mounts, mountTime)
+	pw.summary("rund_unmount_duration_seconds", "Time spent unmounting container rootfs and mounts.", unmounts, unmountTime)
+
+	return pw.err
+}
+
+// metricsWriter writes metrics in Prometheus text format, keeping the first error.
+type metricsWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (m *metricsWriter) printf(format string, args ...interface{}) {
+	if m.err == nil {
+		_, m.err = fmt.Fprintf(m.w, format, args...)
+	}
+}
+
+func (m *metricsWriter) metric(name, kind, help string, value interface{}) {
+	m.printf("# HELP %s %s\n# TYPE %s %s\n%s %v\n", name, help, name, kind, name, value)
+}
+
+func (m *metricsWriter) summary(name, help string, count uint64, sum time.Duration) {
+	m.printf("# HELP %s %s\n# TYPE %s summary\n%s_sum %g\n%s_count %d\n", name, help, name, name, sum.Seconds(), name, count)
+}
@@ -0,0 +1,181 @@
+package containerd
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/containerd/containerd/api/types/runtimeoptions/v1"
+	"github.com/containerd/errdefs"
+	"github.com/containerd/errdefs/pkg/errgrpc"
+	"github.com/containerd/typeurl/v2"
+	"github.com/pelletier/go-toml/v2"
+	"golang.org/x/sys/unix"
+)
+
+func init() {
+	typeurl.Register(&Options{}, "github.com/darwin-containers/rund", "Options")
+}
+
+// Options are runtime options of rund containers.
+//
+// containerd passes them in CreateTaskRequest.Options, either as Options or, when they are set
+// in the options section of the runtime handler in containerd config, as runtimeoptions.Options
+// that point to a TOML config file or carry the TOML itself, for example:
+//
+//	[plugins."io.containerd.cri.v1.runtime".containerd.runtimes.rund.options]
+//	  ConfigPath = "/etc/rund/config.toml"
+//
+// Fields that are not set keep their defaults, unknown fields are rejected.
+type Options struct {
+	// MDNSResponderPath is the host mDNSResponder socket that the container mDNSResponder socket is forwarded to
+	MDNSResponderPath string `json:",omitempty"`
+	// ForceUnmount forces unmounting of container rootfs and mounts even if they are busy, it is true by default
+	ForceUnmount *bool `json:",omitempty"`
+	// DefaultArgs are args of processes whose spec sets none, such processes are rejected by default.
+	// Containers override it with io.rund.default-args annotation.
+	DefaultArgs []string `json:",omitempty"`
+	// ValidationMode is the default validation mode of specs, "permissive" or "strict".
+	// Specs override it with io.rund.validation annotation.
+	ValidationMode string `json:",omitempty"`
+	// AllowedSockets are host unix sockets that containers may forward with io.rund.sockets annotation.
+	// Forwarded socket gives processes of the container access to the host service behind it, so none are allowed by default.
+	AllowedSockets []string `json:",omitempty"`
+}
+
+func defaultOptions() *Options {
+	forceUnmount := true
+
+	return &Options{
+		MDNSResponderPath: mDNSResponderSocket,
+		ForceUnmount:      &forceUnmount,
+		ValidationMode:    string(defaultValidationMode),
+	}
+}
+
+// unmountFlags returns flags for unmounting container rootfs and mounts.
+func (o *Options) unmountFlags() int {
+	if o.ForceUnmount == nil || *o.ForceUnmount {
+		return unix.MNT_FORCE
+	}
+	return 0
+}
+
+// decodeOptions decodes options of CreateTaskRequest, nil options are defaults.
+func decodeOptions(options typeurl.Any) (*Options, error) {
+	result := defaultOptions()
+	if options == nil || options.GetTypeUrl() == "" {
+		return result, nil
+	}
+
+	var err error
+	switch {
+	case typeurl.Is(options, result):
+		err = decodeJSONOptions(options.GetValue(), result)
+	case typeurl.Is(options, &runtimeoptions.Options{}):
+		err = decodeRuntimeOptions(options, result)
+	default:
+		err = fmt.Errorf("unsupported options type %s", options.GetTypeUrl())
+	}
+	if err == nil {
+		err = result.validate()
+	}
+	if err != nil {
+		return nil, errgrpc.ToGRPCf(errdefs.ErrInvalidArgument, "invalid rund options: %v", err)
+	}
+
+	return result, nil
+}
+
+func decodeJSONOptions(data []byte, result *Options) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+
+	if err := decoder.Decode(result); err != nil {
+		// json reports unknown fields as `json: unknown field "Name"`
+		if name, ok := strings.CutPrefix(err.Error(), "json: unknown field "); ok {
+			return fmt.Errorf("unknown option %s", name)
+		}
+		return err
+	}
+
+	return nil
+}
+
+// decodeRuntimeOptions decodes TOML config that options point to or carry.
+func decodeRuntimeOptions(options typeurl.Any, result *Options) error {
+	var ro runtimeoptions.Options
+	if err := typeurl.UnmarshalTo(options, &ro); err != nil {
+		return err
+	}
+
+	config := ro.ConfigBody
+	source := "config body"
+	if ro.ConfigPath != "" {
+		var err error
+		if config, err = os.ReadFile(ro.ConfigPath); err != nil {
+			return err
+		}
+		source = ro.ConfigPath
+	}
+
+	decoder := toml.NewDecoder(bytes.NewReader(config))
+	decoder.DisallowUnknownFields()
+
+	if err := decoder.Decode(result); err != nil {
+		var strict *toml.StrictMissingError
+		if errors.As(err, &strict) {
+			var keys []string
+			for _, e := range strict.Errors {
+				keys = append(keys, strings.Join(e.Key(), "."))
+			}
+			return fmt.Errorf("%s: unknown options %s", source, strings.Join(keys, ", "))
+		}
+
+		var decodeErr *toml.DecodeError
+		if errors.As(err, &decodeErr) {
+			row, column := decodeErr.Position()
+			return fmt.Errorf("%s:%d:%d: %w", source, row, column, err)
+		}
+
+		return fmt.Errorf("%s: %w", source, err)
+	}
+
+	return nil
+}
+
+func (o *Options) validate() error {
+	switch validationMode(o.ValidationMode) {
+	case validationPermissive, validationStrict:
+	default:
+		return fmt.Errorf("ValidationMode must be %q or %q, got %q", validationStrict, validationPermissive, o.ValidationMode)
+	}
+
+	if !filepath.IsAbs(o.MDNSResponderPath) {
+		return fmt.Errorf("MDNSResponderPath must be absolute, got %q", o.MDNSResponderPath)
+	}
+
+	for _, socket := range o.AllowedSockets {
+		if !filepath.IsAbs(socket) {
+			return fmt.Errorf("AllowedSockets must be absolute, got %q", socket)
+		}
+	}
+
+	return nil
+}
+
+// checkSockets rejects forwards of host sockets that are not listed in AllowedSockets.
+func (o *Options) checkSockets(forwards []socketForward) error {
+	for _, f := range forwards {
+		if !slices.ContainsFunc(o.AllowedSockets, func(socket string) bool { return filepath.Clean(socket) == f.hostPath }) {
+			return errgrpc.ToGRPCf(errdefs.ErrInvalidArgument, "host socket %s of %s annotation is not in AllowedSockets option", f.hostPath, socketsAnnotation)
+		}
+	}
+
+	return nil
+}
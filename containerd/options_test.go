@@ -0,0 +1,160 @@
+package containerd
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	taskAPI "github.com/containerd/containerd/api/runtime/task/v3"
+	"github.com/containerd/containerd/api/types/runtimeoptions/v1"
+	"github.com/containerd/errdefs"
+	"github.com/containerd/errdefs/pkg/errgrpc"
+	"github.com/containerd/typeurl/v2"
+	"github.com/darwin-containers/rund/internal/testutil"
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+	"google.golang.org/protobuf/types/known/anypb"
+)
+
+func marshalOptions(t *testing.T, options any) *anypb.Any {
+	t.Helper()
+
+	result, err := typeurl.MarshalAnyToProto(options)
+	require.NoError(t, err)
+
+	return result
+}
+
+func TestDecodeOptions(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "config.toml")
+	require.NoError(t, os.WriteFile(configPath, []byte("ValidationMode = \"strict\"\n"), 0o600))
+
+	disabled := false
+	withDefaults := func(update func(o *Options)) *Options {
+		o := defaultOptions()
+		update(o)
+		return o
+	}
+
+	for _, tc := range []struct {
+		name     string
+		options  typeurl.Any
+		expected *Options
+		err      string
+	}{
+		{
+			name:     "nil",
+			expected: defaultOptions(),
+		},
+		{
+			name:     "typed",
+			options:  marshalOptions(t, &Options{ValidationMode: "strict", DefaultArgs: []string{"/bin/bash"}}),
+			expected: withDefaults(func(o *Options) { o.ValidationMode = "strict"; o.DefaultArgs = []string{"/bin/bash"} }),
+		},
+		{
+			name:    "typed unknown option",
+			options: &anypb.Any{TypeUrl: "github.com/darwin-containers/rund/Options", Value: []byte(`{"Unknown":true}`)},
+			err:     `unknown option "Unknown"`,
+		},
+		{
+			name: "config body",
+			options: marshalOptions(t, &runtimeoptions.Options{ConfigBody: []byte(
+				"MDNSResponderPath = \"/tmp/mDNSResponder\"\nForceUnmount = false\nDefaultArgs = []\n")}),
+			expected: withDefaults(func(o *Options) {
+				o.MDNSResponderPath = "/tmp/mDNSResponder"
+				o.ForceUnmount = &disabled
+				o.DefaultArgs = []string{}
+			}),
+		},
+		{
+			name:     "config path",
+			options:  marshalOptions(t, &runtimeoptions.Options{ConfigPath: configPath}),
+			expected: withDefaults(func(o *Options) { o.ValidationMode = "strict" }),
+		},
+		{
+			name:    "missing config path",
+			options: marshalOptions(t, &runtimeoptions.Options{ConfigPath: filepath.Join(t.TempDir(), "missing.toml")}),
+			err:     "no such file",
+		},
+		{
+			name:    "unknown config options",
+			options: marshalOptions(t, &runtimeoptions.Options{ConfigBody: []byte("Unknown = 1\n[Section]\nKey = 2\n")}),
+			err:     "config body: unknown options Unknown, Section",
+		},
+		{
+			name:    "invalid config",
+			options: marshalOptions(t, &runtimeoptions.Options{ConfigBody: []byte("ValidationMode = \n")}),
+			err:     "config body:1:",
+		},
+		{
+			name:    "invalid validation mode",
+			options: marshalOptions(t, &Options{ValidationMode: "paranoid"}),
+			err:     `ValidationMode must be "strict" or "permissive", got "paranoid"`,
+		},
+		{
+			name:    "relative mDNSResponder path",
+			options: marshalOptions(t, &Options{MDNSResponderPath: "mDNSResponder"}),
+			err:     "MDNSResponderPath must be absolute",
+		},
+		{
+			name:    "relative allowed socket",
+			options: marshalOptions(t, &runtimeoptions.Options{ConfigBody: []byte("AllowedSockets = [\"docker.sock\"]\n")}),
+			err:     `AllowedSockets must be absolute, got "docker.sock"`,
+		},
+		{
+			name:    "unsupported type",
+			options: marshalOptions(t, &specs.Process{}),
+			err:     "unsupported options type",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			options, err := decodeOptions(tc.options)
+			if tc.err != "" {
+				require.True(t, errdefs.IsInvalidArgument(errgrpc.ToNative(err)), err)
+				require.ErrorContains(t, err, tc.err)
+				return
+			}
+
+			require.NoError(t, err)
+			require.Equal(t, tc.expected, options)
+		})
+	}
+}
+
+func TestUnmountFlags(t *testing.T) {
+	disabled := false
+
+	require.Equal(t, unix.MNT_FORCE, defaultOptions().unmountFlags())
+	require.Equal(t, unix.MNT_FORCE, (&Options{}).unmountFlags())
+	require.Zero(t, (&Options{ForceUnmount: &disabled}).unmountFlags())
+}
+
+func TestCreateOptions(t *testing.T) {
+	h := newTestHarness(t)
+
+	// Options set the default validation mode of the container
+	_, err := h.service.Create(h.ctx, &taskAPI.CreateTaskRequest{
+		ID:      "strict",
+		Bundle:  testutil.NewBundle(t, &specs.Spec{Process: testProcess("true"), Hostname: "strict"}),
+		Options: marshalOptions(t, &Options{ValidationMode: "strict"}),
+	})
+	require.True(t, errdefs.IsNotImplemented(errgrpc.ToNative(err)), err)
+
+	// Empty default args reject processes without args
+	_, err = h.service.Create(h.ctx, &taskAPI.CreateTaskRequest{
+		ID:      "no-args",
+		Bundle:  testutil.NewBundle(t, &specs.Spec{Process: testProcess()}),
+		Options: marshalOptions(t, &runtimeoptions.Options{ConfigBody: []byte("DefaultArgs = []\n")}),
+	})
+	require.True(t, errdefs.IsInvalidArgument(errgrpc.ToNative(err)), err)
+	require.ErrorContains(t, err, "process args must not be empty")
+
+	_, err = h.service.Create(h.ctx, &taskAPI.CreateTaskRequest{
+		ID:      "invalid",
+		Bundle:  testutil.NewBundle(t, &specs.Spec{Process: testProcess("true")}),
+		Options: marshalOptions(t, &runtimeoptions.Options{ConfigBody: []byte("Unknown = true\n")}),
+	})
+	require.True(t, errdefs.IsInvalidArgument(errgrpc.ToNative(err)), err)
+	require.ErrorContains(t, err, "unknown options Unknown")
+}
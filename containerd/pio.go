@@ -2,11 +2,14 @@ package containerd
 
 import (
 	"context"
+	"fmt"
 	"io"
 	"os"
+	"os/exec"
 	"syscall"
 
 	"github.com/containerd/fifo"
+	"golang.org/x/sys/unix"
 )
 
 type stdio struct {
@@ -57,3 +60,66 @@ func (s stdio) Close() error {
 	}
 	return nil
 }
+
+// attachOutput makes cmd write stdout and stderr straight to the fifos and returns the descriptors to close once cmd has started.
+// exec.Cmd copies output to writers that aren't files with goroutines that may still be running when exit of the process
+// is reported and the fifos are closed, which would lose the tail of the output.
+func (s stdio) attachOutput(cmd *exec.Cmd) ([]*os.File, error) {
+	var files []*os.File
+	for _, output := range []struct {
+		fifo   io.WriteCloser
+		target *io.Writer
+	}{
+		{s.stdout, &cmd.Stdout},
+		{s.stderr, &cmd.Stderr},
+	} {
+		if output.fifo == nil {
+			continue
+		}
+
+		f, err := dupFile(output.fifo)
+		if err != nil {
+			for _, f := range files {
+				_ = f.Close()
+			}
+			return nil, err
+		}
+
+		files = append(files, f)
+		*output.target = f
+	}
+
+	return files, nil
+}
+
+// dupFile duplicates the descriptor of fifo as a blocking file
+func dupFile(f io.WriteCloser) (*os.File, error) {
+	conn, ok := f.(syscall.Conn)
+	if !ok {
+		return nil, fmt.Errorf("%T has no file descriptor", f)
+	}
+
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+
+	var fd int
+	var dupErr error
+	if err = raw.Control(func(original uintptr) {
+		fd, dupErr = unix.FcntlInt(original, unix.F_DUPFD_CLOEXEC, 0)
+	}); err != nil {
+		return nil, err
+	}
+	if dupErr != nil {
+		return nil, dupErr
+	}
+
+	// Go opens fifos in non-blocking mode, the process would fail writes to a full fifo with EAGAIN
+	if err = unix.SetNonblock(fd, false); err != nil {
+		_ = unix.Close(fd)
+		return nil, err
+	}
+
+	return os.NewFile(uintptr(fd), "fifo"), nil
+}
@@ -0,0 +1,331 @@
+package containerd
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"unicode/utf16"
+)
+
+// parsePlist parses a property list in either XML or binary format.
+// Only dictionaries, arrays, strings, integers and booleans are supported,
+// that is enough for Darwin directory services records.
+func parsePlist(data []byte) (any, error) {
+	if bytes.HasPrefix(data, []byte("bplist00")) {
+		return parseBinaryPlist(data)
+	}
+
+	return parseXMLPlist(data)
+}
+
+func parseXMLPlist(data []byte) (any, error) {
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return nil, fmt.Errorf("malformed plist: %w", err)
+		}
+
+		if start, ok := token.(xml.StartElement); ok && start.Name.Local != "plist" {
+			return parseXMLPlistValue(decoder, start)
+		}
+	}
+}
+
+func parseXMLPlistValue(decoder *xml.Decoder, start xml.StartElement) (any, error) {
+	switch start.Name.Local {
+	case "dict":
+		dict := make(map[string]any)
+		var key *string
+		for {
+			token, err := decoder.Token()
+			if err != nil {
+				return nil, err
+			}
+
+			switch t := token.(type) {
+			case xml.StartElement:
+				if t.Name.Local == "key" {
+					var k string
+					if err := decoder.DecodeElement(&k, &t); err != nil {
+						return nil, err
+					}
+					key = &k
+					continue
+				}
+
+				if key == nil {
+					return nil, fmt.Errorf("malformed plist: %s without key", t.Name.Local)
+				}
+
+				value, err := parseXMLPlistValue(decoder, t)
+				if err != nil {
+					return nil, err
+				}
+				dict[*key] = value
+				key = nil
+			case xml.EndElement:
+				return dict, nil
+			}
+		}
+	case "array":
+		var array []any
+		for {
+			token, err := decoder.Token()
+			if err != nil {
+				return nil, err
+			}
+
+			switch t := token.(type) {
+			case xml.StartElement:
+				value, err := parseXMLPlistValue(decoder, t)
+				if err != nil {
+					return nil, err
+				}
+				array = append(array, value)
+			case xml.EndElement:
+				return array, nil
+			}
+		}
+	case "string", "integer":
+		var s string
+		if err := decoder.DecodeElement(&s, &start); err != nil {
+			return nil, err
+		}
+		return s, nil
+	case "true", "false":
+		return start.Name.Local == "true", decoder.Skip()
+	}
+
+	return nil, fmt.Errorf("unsupported plist element: %s", start.Name.Local)
+}
+
+// maxBinaryPlistVisits bounds object references followed while parsing a binary plist
+const maxBinaryPlistVisits = 1 << 16
+
+type binaryPlist struct {
+	data          []byte
+	offsets       []uint64
+	objectRefSize int
+
+	// decoded caches objects by ref, as many references may point to the same object
+	decoded map[uint64]any
+	visits  int
+}
+
+func parseBinaryPlist(data []byte) (any, error) {
+	const trailerSize = 32
+	if len(data) < 8+trailerSize {
+		return nil, errors.New("malformed binary plist: too short")
+	}
+
+	trailer := data[len(data)-trailerSize:]
+	offsetIntSize := int(trailer[6])
+	objectRefSize := int(trailer[7])
+	numObjects := binary.BigEndian.Uint64(trailer[8:])
+	topObject := binary.BigEndian.Uint64(trailer[16:])
+	offsetTableOffset := binary.BigEndian.Uint64(trailer[24:])
+
+	if offsetIntSize == 0 || offsetIntSize > 8 || objectRefSize == 0 || objectRefSize > 8 {
+		return nil, errors.New("malformed binary plist: invalid trailer")
+	}
+
+	if offsetTableOffset > uint64(len(data)) || numObjects > (uint64(len(data))-offsetTableOffset)/uint64(offsetIntSize) {
+		return nil, errors.New("malformed binary plist: invalid offset table")
+	}
+
+	p := &binaryPlist{
+		data:          data,
+		offsets:       make([]uint64, numObjects),
+		objectRefSize: objectRefSize,
+		decoded:       make(map[uint64]any),
+	}
+
+	for i := range p.offsets {
+		start := offsetTableOffset + uint64(i*offsetIntSize)
+		p.offsets[i] = readBigEndian(data[start : start+uint64(offsetIntSize)])
+	}
+
+	// Depth limit protects from reference cycles
+	return p.object(topObject, 32)
+}
+
+func readBigEndian(b []byte) uint64 {
+	var result uint64
+	for _, c := range b {
+		result = result<<8 | uint64(c)
+	}
+	return result
+}
+
+func (p *binaryPlist) bytes(offset, length uint64) ([]byte, error) {
+	if offset > uint64(len(p.data)) || length > uint64(len(p.data))-offset {
+		return nil, io.ErrUnexpectedEOF
+	}
+	return p.data[offset : offset+length], nil
+}
+
+// count reads object size that is either stored in marker or in a following integer object
+func (p *binaryPlist) count(offset uint64, info byte) (uint64, uint64, error) {
+	if info != 0xf {
+		return uint64(info), offset + 1, nil
+	}
+
+	marker, err := p.bytes(offset+1, 1)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	if marker[0]>>4 != 0x1 {
+		return 0, 0, errors.New("malformed binary plist: invalid object size")
+	}
+
+	size := uint64(1) << (marker[0] & 0xf)
+	b, err := p.bytes(offset+2, size)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	count := readBigEndian(b)
+	if count > uint64(len(p.data)) {
+		return 0, 0, io.ErrUnexpectedEOF
+	}
+
+	return count, offset + 2 + size, nil
+}
+
+func (p *binaryPlist) refs(offset, count uint64) ([]uint64, error) {
+	b, err := p.bytes(offset, count*uint64(p.objectRefSize))
+	if err != nil {
+		return nil, err
+	}
+
+	refs := make([]uint64, count)
+	for i := range refs {
+		refs[i] = readBigEndian(b[i*p.objectRefSize : (i+1)*p.objectRefSize])
+	}
+	return refs, nil
+}
+
+// object returns the object with the given ref, every object is decoded once.
+func (p *binaryPlist) object(ref uint64, depth int) (any, error) {
+	p.visits++
+	if p.visits > maxBinaryPlistVisits {
+		return nil, errors.New("malformed binary plist: too many object references")
+	}
+
+	if value, ok := p.decoded[ref]; ok {
+		return value, nil
+	}
+
+	if ref >= uint64(len(p.offsets)) || depth == 0 {
+		return nil, errors.New("malformed binary plist: invalid object reference")
+	}
+
+	value, err := p.decode(ref, depth)
+	if err != nil {
+		return nil, err
+	}
+	p.decoded[ref] = value
+
+	return value, nil
+}
+
+func (p *binaryPlist) decode(ref uint64, depth int) (any, error) {
+	offset := p.offsets[ref]
+	marker, err := p.bytes(offset, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	kind, info := marker[0]>>4, marker[0]&0xf
+	switch kind {
+	case 0x0:
+		switch info {
+		case 0x8:
+			return false, nil
+		case 0x9:
+			return true, nil
+		}
+	case 0x1:
+		b, err := p.bytes(offset+1, uint64(1)<<info)
+		if err != nil {
+			return nil, err
+		}
+		return strconv.FormatInt(int64(readBigEndian(b)), 10), nil
+	case 0x5:
+		length, start, err := p.count(offset, info)
+		if err != nil {
+			return nil, err
+		}
+		b, err := p.bytes(start, length)
+		if err != nil {
+			return nil, err
+		}
+		return string(b), nil
+	case 0x6:
+		length, start, err := p.count(offset, info)
+		if err != nil {
+			return nil, err
+		}
+		b, err := p.bytes(start, length*2)
+		if err != nil {
+			return nil, err
+		}
+		chars := make([]uint16, length)
+		for i := range chars {
+			chars[i] = binary.BigEndian.Uint16(b[i*2:])
+		}
+		return string(utf16.Decode(chars)), nil
+	case 0xa:
+		count, start, err := p.count(offset, info)
+		if err != nil {
+			return nil, err
+		}
+		refs, err := p.refs(start, count)
+		if err != nil {
+			return nil, err
+		}
+		array := make([]any, 0, count)
+		for _, r := range refs {
+			value, err := p.object(r, depth-1)
+			if err != nil {
+				return nil, err
+			}
+			array = append(array, value)
+		}
+		return array, nil
+	case 0xd:
+		count, start, err := p.count(offset, info)
+		if err != nil {
+			return nil, err
+		}
+		refs, err := p.refs(start, count*2)
+		if err != nil {
+			return nil, err
+		}
+		dict := make(map[string]any, count)
+		for i := uint64(0); i < count; i++ {
+			key, err := p.object(refs[i], depth-1)
+			if err != nil {
+				return nil, err
+			}
+			k, ok := key.(string)
+			if !ok {
+				return nil, errors.New("malformed binary plist: dictionary key is not a string")
+			}
+			value, err := p.object(refs[count+i], depth-1)
+			if err != nil {
+				return nil, err
+			}
+			dict[k] = value
+		}
+		return dict, nil
+	}
+
+	return nil, fmt.Errorf("unsupported binary plist object type: %#x", marker[0])
+}
@@ -25,6 +25,9 @@ func init() {
 			if err != nil {
 				return nil, err
 			}
+			if err = setupTracing(ic.Context, ss.(shutdown.Service)); err != nil {
+				return nil, err
+			}
 			return NewTaskService(ic.Context, pp.(shim.Publisher), ss.(shutdown.Service))
 		},
 	})
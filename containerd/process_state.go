@@ -0,0 +1,136 @@
+package containerd
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/containerd/containerd/api/types/task"
+	"github.com/containerd/errdefs"
+	"github.com/containerd/errdefs/pkg/errgrpc"
+	"golang.org/x/sys/unix"
+)
+
+// processState is the state machine of a managed process.
+// Valid transitions are created → running → stopped, running ↔ paused, and any state → stopped.
+type processState struct {
+	mu sync.Mutex
+	processStatus
+}
+
+// processStatus is a snapshot of processState
+type processStatus struct {
+	status     task.Status
+	pid        int
+	exitStatus uint32
+	exitedAt   time.Time
+}
+
+func (s *processState) get() processStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.processStatus
+}
+
+// require returns an error if process is not in one of the allowed statuses.
+func (s *processState) require(action string, allowed ...task.Status) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.requireL(action, allowed...)
+}
+
+func (s *processState) requireL(action string, allowed ...task.Status) error {
+	if slices.Contains(allowed, s.status) {
+		return nil
+	}
+
+	return errgrpc.ToGRPCf(errdefs.ErrFailedPrecondition, "cannot %s %s process", action, strings.ToLower(s.status.String()))
+}
+
+func (s *processState) transition(action string, to task.Status, from ...task.Status) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.requireL(action, from...); err != nil {
+		return err
+	}
+
+	s.status = to
+
+	return nil
+}
+
+// start records that process with the given pid has started.
+func (s *processState) start(pid int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.requireL("start", task.Status_CREATED); err != nil {
+		return err
+	}
+
+	s.status = task.Status_RUNNING
+	s.pid = pid
+
+	return nil
+}
+
+func (s *processState) pause() error {
+	return s.transition("pause", task.Status_PAUSED, task.Status_RUNNING)
+}
+
+func (s *processState) resume() error {
+	return s.transition("resume", task.Status_RUNNING, task.Status_PAUSED)
+}
+
+// signal sends signal to the process group of the process while it is running or paused.
+// The lock is held while signaling, so the process group is not signaled once its exit is recorded.
+// Signal to a process that has exited is a NotFound error, process that has never been started is not signaled.
+func (s *processState) signal(signal syscall.Signal) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.status == task.Status_STOPPED {
+		return errgrpc.ToGRPCf(errdefs.ErrNotFound, "process already finished")
+	}
+	if s.pid == 0 || (s.status != task.Status_RUNNING && s.status != task.Status_PAUSED) {
+		return nil
+	}
+
+	if err := unix.Kill(-s.pid, signal); err != nil {
+		if errors.Is(err, unix.ESRCH) {
+			return errgrpc.ToGRPCf(errdefs.ErrNotFound, "process already finished")
+		}
+		return err
+	}
+
+	return nil
+}
+
+// exit records process exit. It returns false if process is already stopped,
+// the first recorded exit wins.
+// Unless nil, fn is called with the recorded exit while holding the lock,
+// so whoever observes the process stopped also observes the effects of fn.
+func (s *processState) exit(exitStatus uint32, exitedAt time.Time, fn func(processStatus)) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.status == task.Status_STOPPED {
+		return false
+	}
+
+	s.status = task.Status_STOPPED
+	s.exitStatus = exitStatus
+	s.exitedAt = exitedAt
+
+	if fn != nil {
+		fn(s.processStatus)
+	}
+
+	return true
+}
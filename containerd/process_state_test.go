@@ -0,0 +1,92 @@
+package containerd
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/containerd/containerd/api/types/task"
+	"github.com/containerd/errdefs"
+	"github.com/containerd/errdefs/pkg/errgrpc"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProcessStateTransitions(t *testing.T) {
+	var s processState
+	s.status = task.Status_CREATED
+
+	require.ErrorIs(t, errgrpc.ToNative(s.pause()), errdefs.ErrFailedPrecondition)
+	require.ErrorIs(t, errgrpc.ToNative(s.resume()), errdefs.ErrFailedPrecondition)
+
+	require.NoError(t, s.start(42))
+	require.Equal(t, processStatus{status: task.Status_RUNNING, pid: 42}, s.get())
+	require.ErrorIs(t, errgrpc.ToNative(s.start(43)), errdefs.ErrFailedPrecondition)
+
+	require.NoError(t, s.pause())
+	require.Equal(t, task.Status_PAUSED, s.get().status)
+	require.ErrorIs(t, errgrpc.ToNative(s.pause()), errdefs.ErrFailedPrecondition)
+
+	require.NoError(t, s.resume())
+	require.Equal(t, task.Status_RUNNING, s.get().status)
+
+	exitedAt := time.Now()
+	var recorded []processStatus
+	record := func(st processStatus) {
+		recorded = append(recorded, st)
+	}
+
+	require.True(t, s.exit(1, exitedAt, record))
+	require.False(t, s.exit(2, time.Now(), record))
+	require.Equal(t, []processStatus{{status: task.Status_STOPPED, pid: 42, exitStatus: 1, exitedAt: exitedAt}}, recorded)
+	require.Equal(t, processStatus{status: task.Status_STOPPED, pid: 42, exitStatus: 1, exitedAt: exitedAt}, s.get())
+
+	require.ErrorIs(t, errgrpc.ToNative(s.start(44)), errdefs.ErrFailedPrecondition)
+	require.ErrorIs(t, errgrpc.ToNative(s.resume()), errdefs.ErrFailedPrecondition)
+}
+
+func TestProcessStateConcurrentExit(t *testing.T) {
+	var s processState
+	s.status = task.Status_CREATED
+	require.NoError(t, s.start(42))
+
+	const count = 100
+
+	var (
+		wg     sync.WaitGroup
+		mu     sync.Mutex
+		winner = -1
+	)
+
+	for i := 0; i < count; i++ {
+		wg.Add(2)
+
+		go func() {
+			defer wg.Done()
+
+			if s.exit(uint32(i), time.Now(), nil) {
+				mu.Lock()
+				defer mu.Unlock()
+
+				require.Equal(t, -1, winner)
+				winner = i
+			}
+		}()
+
+		go func() {
+			defer wg.Done()
+
+			// Snapshot is never torn, stopped process always has exit time
+			if st := s.get(); st.status == task.Status_STOPPED {
+				require.False(t, st.exitedAt.IsZero())
+			} else {
+				_ = s.pause()
+				_ = s.resume()
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	require.NotEqual(t, -1, winner)
+	require.Equal(t, uint32(winner), s.get().exitStatus)
+}
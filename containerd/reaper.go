@@ -0,0 +1,88 @@
+package containerd
+
+import (
+	"os"
+	"sync/atomic"
+	"syscall"
+)
+
+// processExit is the exit status of a reaped process.
+// It mirrors the subset of os.ProcessState API used by rund.
+type processExit struct {
+	pid    int
+	status syscall.WaitStatus
+}
+
+// ExitCode returns the exit code of the exited process, or -1 if the process was terminated by a signal.
+func (e *processExit) ExitCode() int {
+	return e.status.ExitStatus()
+}
+
+// ExitStatus returns the exit status reported to containerd, that is 128+signal if the process was terminated by a signal.
+func (e *processExit) ExitStatus() uint32 {
+	if e.status.Signaled() {
+		return uint32(128 + e.status.Signal())
+	}
+	return uint32(e.status.ExitStatus())
+}
+
+func (e *processExit) Sys() any {
+	return e.status
+}
+
+// watchProcessGroup terminates processes of the group and calls fn once all of them exit.
+func watchProcessGroup(pgid int, fn func(error)) {
+	pids, err := processGroupMembers(pgid)
+	if err != nil {
+		fn(err)
+		return
+	}
+
+	if len(pids) == 0 {
+		fn(nil)
+		return
+	}
+
+	_ = syscall.Kill(-pgid, syscall.SIGTERM)
+
+	// New processes may have been forked in the meantime, so the group is checked again once all known members exit
+	var remaining atomic.Int32
+	remaining.Store(int32(len(pids)))
+	exited := func() {
+		if remaining.Add(-1) == 0 {
+			watchProcessGroup(pgid, fn)
+		}
+	}
+
+	for _, pid := range pids {
+		if err := watchExit(pid, exited); err != nil {
+			fn(err)
+			return
+		}
+	}
+}
+
+// wait waits for process and then terminates and waits for the rest of its process group.
+func wait(process *os.Process) (*processExit, error) {
+	return waitFor(process, true)
+}
+
+// waitProcess waits for process to exit.
+func waitProcess(process *os.Process) (*processExit, error) {
+	return waitFor(process, false)
+}
+
+func waitFor(process *os.Process, waitProcessGroup bool) (*processExit, error) {
+	type result struct {
+		exit *processExit
+		err  error
+	}
+
+	done := make(chan result, 1)
+	onExit(process, waitProcessGroup, func(exit *processExit, err error) {
+		done <- result{exit, err}
+	})
+
+	r := <-done
+	return r.exit, r.err
+}
@@ -2,9 +2,11 @@ package containerd
 
 import (
 	"os"
+	"os/exec"
+	"sync"
 	"syscall"
-	"time"
 
+	"github.com/containerd/log"
 	"golang.org/x/sys/unix"
 )
 
@@ -12,55 +14,150 @@ import (
 // See https://github.com/bazelbuild/bazel/commit/b07b799cdf25661f1d59a8fd9d941c702886d3b8
 // See https://chromium.googlesource.com/chromium/src/base/+/master/process/kill_mac.cc
 
-func waitUntilZombie(process *os.Process) error {
-	kq, err := unix.Kqueue()
+// szomb is the SZOMB process state from sys/proc.h
+const szomb = 5
+
+// exitMonitor is the single exit monitor of the shim.
+// It watches process exits using EVFILT_PROC filter of a single kqueue.
+type exitMonitor struct {
+	kq int
+
+	mu sync.Mutex
+	// watchers maps pids to callbacks called once the process exits
+	watchers map[int][]func()
+}
+
+var (
+	defaultMonitor = &exitMonitor{
+		watchers: make(map[int][]func()),
+	}
+	monitorOnce sync.Once
+	monitorErr  error
+)
+
+// setupMonitor starts the monitor loop.
+func setupMonitor() (*exitMonitor, error) {
+	monitorOnce.Do(func() {
+		m := defaultMonitor
+
+		if m.kq, monitorErr = unix.Kqueue(); monitorErr != nil {
+			return
+		}
+		unix.CloseOnExec(m.kq)
+
+		go m.poll()
+	})
+
+	return defaultMonitor, monitorErr
+}
+
+func (m *exitMonitor) poll() {
+	events := make([]unix.Kevent_t, 32)
+	for {
+		n, err := unix.Kevent(m.kq, nil, events, nil)
+		if err != nil {
+			if err != unix.EINTR {
+				log.L.WithError(err).Error("exit monitor failed")
+			}
+			continue
+		}
+
+		m.mu.Lock()
+		for _, event := range events[:n] {
+			pid := int(event.Ident)
+			for _, fn := range m.watchers[pid] {
+				go fn()
+			}
+			delete(m.watchers, pid)
+		}
+		m.mu.Unlock()
+	}
+}
+
+// watchExit calls fn once process with the given pid exits.
+// Process that is already a zombie is considered exited.
+func watchExit(pid int, fn func()) error {
+	m, err := setupMonitor()
 	if err != nil {
 		return err
 	}
-	defer unix.Close(kq)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
 
 	changes := []unix.Kevent_t{
 		{
-			Ident:  uint64(process.Pid),
+			Ident:  uint64(pid),
 			Filter: unix.EVFILT_PROC,
-			Flags:  unix.EV_ADD,
+			Flags:  unix.EV_ADD | unix.EV_ONESHOT,
 			Fflags: unix.NOTE_EXIT,
 		},
 	}
 
-	ke := []unix.Kevent_t{
-		{},
+	if _, err := unix.Kevent(m.kq, changes, nil, nil); err != nil {
+		if err == unix.ESRCH {
+			go fn()
+			return nil
+		}
+		return err
 	}
 
-	_, err = unix.Kevent(kq, changes, ke, nil)
-	return err
+	m.watchers[pid] = append(m.watchers[pid], fn)
+
+	return nil
 }
 
-func waitForProcessGroup(process *os.Process) error {
-	for {
-		procs, err := unix.SysctlKinfoProcSlice("kern.proc.pgrp", process.Pid)
-		if err != nil {
-			return err
-		}
+// processGroupMembers returns pids of processes in the given process group that haven't exited yet.
+func processGroupMembers(pgid int) ([]int, error) {
+	procs, err := unix.SysctlKinfoProcSlice("kern.proc.pgrp", pgid)
+	if err != nil {
+		return nil, err
+	}
 
-		if len(procs) <= 1 {
-			return nil
+	var pids []int
+	for _, proc := range procs {
+		if proc.Proc.P_stat != szomb {
+			pids = append(pids, int(proc.Proc.P_pid))
 		}
+	}
 
-		_ = syscall.Kill(-process.Pid, syscall.SIGTERM)
+	return pids, nil
+}
 
-		time.Sleep(10 * time.Millisecond)
-	}
+// startCommand starts cmd using start function.
+func startCommand(_ *exec.Cmd, start func() error) error {
+	return start()
 }
 
-func wait(process *os.Process) (*os.ProcessState, error) {
-	if err := waitUntilZombie(process); err != nil {
-		return nil, err
-	}
+// onExit calls fn once process exits, and, if waitProcessGroup is set,
+// the rest of its process group is terminated and exits as well.
+// Process group leader is kept as a zombie until the group is empty so its pid can't be reused.
+func onExit(process *os.Process, waitProcessGroup bool, fn func(*processExit, error)) {
+	reap := func() {
+		state, err := process.Wait()
+		if err != nil {
+			fn(nil, err)
+			return
+		}
 
-	if err := waitForProcessGroup(process); err != nil {
-		return nil, err
+		fn(&processExit{pid: process.Pid, status: state.Sys().(syscall.WaitStatus)}, nil)
 	}
 
-	return process.Wait()
+	err := watchExit(process.Pid, func() {
+		if !waitProcessGroup {
+			reap()
+			return
+		}
+
+		watchProcessGroup(process.Pid, func(err error) {
+			if err != nil {
+				fn(nil, err)
+			} else {
+				reap()
+			}
+		})
+	})
+	if err != nil {
+		go fn(nil, err)
+	}
 }
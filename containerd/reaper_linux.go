@@ -1,17 +1,325 @@
 package containerd
 
 import (
+	"bytes"
 	"errors"
-	"golang.org/x/sys/unix"
+	"fmt"
 	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"syscall"
+
+	"github.com/containerd/log"
+	"golang.org/x/sys/unix"
+)
+
+// exitMonitor is the single exit monitor of the shim.
+//
+// It is a child subreaper that collects exit statuses of all shim children in a SIGCHLD loop.
+// Orphaned container processes are reparented to the shim and reaped here as well,
+// so they don't stay around as zombies.
+// Exits of processes that are not shim children are watched using pidfds in a single epoll loop.
+//
+// Processes of rund must be started with startCommand, so their exit statuses are dispatched to onExit.
+// Children started otherwise, e.g. by libraries, are reaped here as well, so exec.Cmd.Wait and os.Process.Wait
+// of such children fail with ECHILD. Their exit statuses are kept in reaped, so onExit still reports them.
+type exitMonitor struct {
+	// mu is held while reaping and while starting a process,
+	// so the exit of a just started process can't be reaped before it is registered.
+	mu sync.Mutex
+	// running maps pids of started processes until they are reaped
+	running map[int]*child
+	// children holds started processes until their exit is subscribed to
+	children map[*os.Process]*child
+	// reaped maps pids of reaped processes that are not started with startCommand to their exit statuses,
+	// reapedOrder holds the same pids oldest first, so that at most maxReapedExits are kept
+	reaped      map[int]syscall.WaitStatus
+	reapedOrder []int
+
+	epfd int
+	// pidfds maps watched pidfds to callbacks
+	pidfds map[int]func()
+}
+
+type child struct {
+	exited bool
+	status syscall.WaitStatus
+	onExit func(syscall.WaitStatus)
+}
+
+// maxReapedExits bounds exit statuses of unknown processes kept for late waiters,
+// orphaned container processes are reaped as unknown processes too and nobody waits for them
+const maxReapedExits = 1024
+
+var (
+	defaultMonitor = &exitMonitor{
+		running:  make(map[int]*child),
+		children: make(map[*os.Process]*child),
+		reaped:   make(map[int]syscall.WaitStatus),
+		pidfds:   make(map[int]func()),
+	}
+	monitorOnce sync.Once
+	monitorErr  error
 )
 
-func wait(process *os.Process) (*os.ProcessState, error) {
-	_, err := unix.Wait4(-process.Pid, nil, 0, nil)
+// setupMonitor makes the current process a child subreaper and starts the monitor loops.
+func setupMonitor() (*exitMonitor, error) {
+	monitorOnce.Do(func() {
+		m := defaultMonitor
+
+		if monitorErr = unix.Prctl(unix.PR_SET_CHILD_SUBREAPER, 1, 0, 0, 0); monitorErr != nil {
+			monitorErr = fmt.Errorf("failed to become child subreaper: %w", monitorErr)
+			return
+		}
+
+		if m.epfd, monitorErr = unix.EpollCreate1(unix.EPOLL_CLOEXEC); monitorErr != nil {
+			return
+		}
+
+		signals := make(chan os.Signal, 32)
+		signal.Notify(signals, unix.SIGCHLD)
+
+		go func() {
+			for range signals {
+				m.reap()
+			}
+		}()
+
+		go m.poll()
+	})
+
+	return defaultMonitor, monitorErr
+}
+
+func (m *exitMonitor) reap() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for {
+		var status unix.WaitStatus
+		pid, err := unix.Wait4(-1, &status, unix.WNOHANG, nil)
+		if err == unix.EINTR {
+			continue
+		}
+
+		if err != nil || pid <= 0 {
+			return
+		}
+
+		c, ok := m.running[pid]
+		if !ok {
+			log.L.WithField("pid", pid).Debug("reaped unknown process")
+			m.keepReaped(pid, syscall.WaitStatus(status))
+			continue
+		}
+
+		delete(m.running, pid)
+		c.exited = true
+		c.status = syscall.WaitStatus(status)
+		if c.onExit != nil {
+			go c.onExit(c.status)
+		}
+	}
+}
+
+// keepReaped records exit status of a reaped process that is not started with startCommand.
+func (m *exitMonitor) keepReaped(pid int, status syscall.WaitStatus) {
+	if len(m.reapedOrder) >= maxReapedExits {
+		delete(m.reaped, m.reapedOrder[0])
+		m.reapedOrder = m.reapedOrder[1:]
+	}
+
+	m.reaped[pid] = status
+	m.reapedOrder = append(m.reapedOrder, pid)
+}
+
+func (m *exitMonitor) poll() {
+	events := make([]unix.EpollEvent, 32)
+	for {
+		n, err := unix.EpollWait(m.epfd, events, -1)
+		if err != nil {
+			if err != unix.EINTR {
+				log.L.WithError(err).Error("exit monitor failed")
+			}
+			continue
+		}
+
+		m.mu.Lock()
+		for _, event := range events[:n] {
+			fd := int(event.Fd)
+			if fn, ok := m.pidfds[fd]; ok {
+				delete(m.pidfds, fd)
+				_ = unix.EpollCtl(m.epfd, unix.EPOLL_CTL_DEL, fd, nil)
+				_ = unix.Close(fd)
+				go fn()
+			}
+		}
+		m.mu.Unlock()
+	}
+}
+
+// startCommand starts cmd using start function and registers it in the exit monitor.
+func startCommand(cmd *exec.Cmd, start func() error) error {
+	m, err := setupMonitor()
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := start(); err != nil {
+		return err
+	}
 
-	if err != nil && !errors.Is(err, unix.ECHILD) {
+	c := &child{}
+	m.running[cmd.Process.Pid] = c
+	m.children[cmd.Process] = c
+	// The pid may be reused from an unknown process that has been reaped
+	delete(m.reaped, cmd.Process.Pid)
+
+	return nil
+}
+
+// watchExit calls fn once process with the given pid exits.
+func watchExit(pid int, fn func()) error {
+	m, err := setupMonitor()
+	if err != nil {
+		return err
+	}
+
+	fd, err := unix.PidfdOpen(pid, 0)
+	if err != nil {
+		if err == unix.ESRCH {
+			go fn()
+			return nil
+		}
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := unix.EpollCtl(m.epfd, unix.EPOLL_CTL_ADD, fd, &unix.EpollEvent{Events: unix.EPOLLIN, Fd: int32(fd)}); err != nil {
+		_ = unix.Close(fd)
+		return err
+	}
+	m.pidfds[fd] = fn
+
+	return nil
+}
+
+// processGroupMembers returns pids of processes in the given process group that haven't exited yet.
+func processGroupMembers(pgid int) ([]int, error) {
+	stats, err := filepath.Glob("/proc/[0-9]*/stat")
+	if err != nil {
 		return nil, err
 	}
 
-	return process.Wait()
+	var pids []int
+	for _, stat := range stats {
+		data, err := os.ReadFile(stat)
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) || errors.Is(err, unix.ESRCH) {
+				// Process is already gone
+				continue
+			}
+			return nil, err
+		}
+
+		// Format is "pid (comm) state ppid pgrp ...", comm may contain spaces and parentheses
+		i := bytes.LastIndexByte(data, ')')
+		if i < 0 {
+			return nil, fmt.Errorf("malformed %s", stat)
+		}
+
+		fields := bytes.Fields(data[i+1:])
+		if len(fields) < 3 {
+			return nil, fmt.Errorf("malformed %s", stat)
+		}
+
+		if string(fields[0]) == "Z" || string(fields[0]) == "X" {
+			continue
+		}
+
+		if pgrp, err := strconv.Atoi(string(fields[2])); err != nil || pgrp != pgid {
+			continue
+		}
+
+		pid, err := strconv.Atoi(filepath.Base(filepath.Dir(stat)))
+		if err != nil {
+			return nil, err
+		}
+
+		pids = append(pids, pid)
+	}
+
+	return pids, nil
+}
+
+// onExit calls fn once process exits, and, if waitProcessGroup is set,
+// the rest of its process group is terminated and exits as well.
+// Process must be a child of the shim, that is started with startCommand or not waited for otherwise.
+func onExit(process *os.Process, waitProcessGroup bool, fn func(*processExit, error)) {
+	m, err := setupMonitor()
+	if err != nil {
+		go fn(nil, err)
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	c, ok := m.children[process]
+	if ok {
+		delete(m.children, process)
+	} else if c, err = m.adoptL(process.Pid); err != nil {
+		go fn(nil, err)
+		return
+	}
+
+	c.onExit = func(status syscall.WaitStatus) {
+		exit := &processExit{pid: process.Pid, status: status}
+
+		if !waitProcessGroup {
+			fn(exit, nil)
+			return
+		}
+
+		watchProcessGroup(process.Pid, func(err error) {
+			if err != nil {
+				fn(nil, err)
+			} else {
+				fn(exit, nil)
+			}
+		})
+	}
+
+	if c.exited {
+		go c.onExit(c.status)
+	}
+}
+
+// adoptL returns child that tracks exit of a process that is not started with startCommand.
+// Its exit status is taken from reaped if it has already been reaped, m.mu must be held.
+func (m *exitMonitor) adoptL(pid int) (*child, error) {
+	if status, ok := m.reaped[pid]; ok {
+		delete(m.reaped, pid)
+		return &child{exited: true, status: status}, nil
+	}
+
+	if _, ok := m.running[pid]; !ok {
+		// Fails with ECHILD if the process is not a child of the shim
+		var info unix.Siginfo
+		if err := unix.Waitid(unix.P_PID, pid, &info, unix.WEXITED|unix.WNOHANG|unix.WNOWAIT, nil); err == nil {
+			c := &child{}
+			m.running[pid] = c
+			return c, nil
+		}
+	}
+
+	return nil, fmt.Errorf("process %d is not a child of rund or already waited for: %w", pid, unix.ECHILD)
 }
@@ -0,0 +1,77 @@
+package containerd
+
+import (
+	"bufio"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+)
+
+func TestReapOrphans(t *testing.T) {
+	// Grandchild leaves process group of its parent, so it is not waited for and becomes an orphan
+	cmd := exec.Command("/bin/sh", "-c", "setsid /bin/sh -c 'sleep 0.2' >/dev/null & echo $!")
+	stdout, err := cmd.StdoutPipe()
+	require.NoError(t, err)
+	require.NoError(t, startCommand(cmd, cmd.Start))
+
+	line, err := bufio.NewReader(stdout).ReadString('\n')
+	require.NoError(t, err)
+	orphan, err := strconv.Atoi(strings.TrimSpace(line))
+	require.NoError(t, err)
+
+	w, err := waitProcess(cmd.Process)
+	require.NoError(t, err)
+	require.Equal(t, 0, w.ExitCode())
+
+	require.Eventually(t, func() bool {
+		_, err := os.Stat(filepath.Join("/proc", strconv.Itoa(orphan)))
+		return os.IsNotExist(err)
+	}, 5*time.Second, 10*time.Millisecond, "orphan is not reaped")
+}
+
+func TestWaitProcessNotChild(t *testing.T) {
+	process, err := os.FindProcess(1)
+	require.NoError(t, err)
+
+	_, err = waitProcess(process)
+	require.ErrorIs(t, err, unix.ECHILD)
+}
+
+func TestWaitProcessNotStarted(t *testing.T) {
+	// Children that are not started with startCommand are reaped by the monitor too,
+	// their exit is reported both to waiters that come after it has been reaped and to those that come before
+	for _, script := range []string{"exit 3", "sleep 0.2; exit 3"} {
+		t.Run(script, func(t *testing.T) {
+			_, err := setupMonitor()
+			require.NoError(t, err)
+
+			process, err := os.StartProcess("/bin/sh", []string{"/bin/sh", "-c", script}, &os.ProcAttr{})
+			require.NoError(t, err)
+
+			if script == "exit 3" {
+				require.Eventually(t, func() bool {
+					defaultMonitor.mu.Lock()
+					defer defaultMonitor.mu.Unlock()
+
+					_, ok := defaultMonitor.reaped[process.Pid]
+					return ok
+				}, 5*time.Second, 10*time.Millisecond, "process is not reaped")
+			}
+
+			w, err := waitProcess(process)
+			require.NoError(t, err)
+			require.Equal(t, 3, w.ExitCode())
+
+			// Exit is reported once
+			_, err = waitProcess(process)
+			require.ErrorIs(t, err, unix.ECHILD)
+		})
+	}
+}
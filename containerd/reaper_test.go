@@ -1,30 +1,119 @@
 package containerd
 
 import (
-	"os"
+	"bufio"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
 	"syscall"
 	"testing"
+	"time"
 
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
 
 func TestWaitExit(t *testing.T) {
-	process, err := os.StartProcess("/bin/sh", []string{"/bin/sh", "-c", "exit 42"}, &os.ProcAttr{})
-	require.NoError(t, err)
+	cmd := exec.Command("/bin/sh", "-c", "exit 42")
+	require.NoError(t, startCommand(cmd, cmd.Start))
 
-	w, err := wait(process)
+	w, err := wait(cmd.Process)
 	require.NoError(t, err)
 	require.Equal(t, 42, w.ExitCode())
 }
 
 func TestWaitKill(t *testing.T) {
-	process, err := os.StartProcess("/bin/sh", []string{"/bin/sh", "-c", "sleep 60"}, &os.ProcAttr{})
-	require.NoError(t, err)
+	cmd := exec.Command("/bin/sh", "-c", "sleep 60")
+	require.NoError(t, startCommand(cmd, cmd.Start))
 
-	err = process.Kill()
+	err := cmd.Process.Kill()
 	require.NoError(t, err)
 
-	w, err := wait(process)
+	w, err := wait(cmd.Process)
 	require.NoError(t, err)
 	require.Equal(t, int(syscall.SIGKILL), int(w.Sys().(syscall.WaitStatus)))
 }
+
+func TestWaitProcessGroup(t *testing.T) {
+	cmd := exec.Command("/bin/sh", "-c", "sleep 60 >/dev/null & echo $!")
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	stdout, err := cmd.StdoutPipe()
+	require.NoError(t, err)
+	require.NoError(t, startCommand(cmd, cmd.Start))
+
+	line, err := bufio.NewReader(stdout).ReadString('\n')
+	require.NoError(t, err)
+	grandchild, err := strconv.Atoi(strings.TrimSpace(line))
+	require.NoError(t, err)
+
+	w, err := wait(cmd.Process)
+	require.NoError(t, err)
+	require.Equal(t, 0, w.ExitCode())
+
+	pids, err := processGroupMembers(cmd.Process.Pid)
+	require.NoError(t, err)
+	require.Empty(t, pids)
+	require.NotContains(t, pids, grandchild)
+}
+
+func TestWaitProcessGroupNested(t *testing.T) {
+	cmd := exec.Command("/bin/sh", "-c", "/bin/sh -c 'sleep 60 & sleep 60' >/dev/null & exit 7")
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	require.NoError(t, startCommand(cmd, cmd.Start))
+
+	w, err := wait(cmd.Process)
+	require.NoError(t, err)
+	require.Equal(t, 7, w.ExitCode())
+
+	pids, err := processGroupMembers(cmd.Process.Pid)
+	require.NoError(t, err)
+	require.Empty(t, pids)
+}
+
+func TestWatchExit(t *testing.T) {
+	cmd := exec.Command("/bin/sh", "-c", "sleep 0.2 >/dev/null & echo $!; wait")
+	stdout, err := cmd.StdoutPipe()
+	require.NoError(t, err)
+	require.NoError(t, startCommand(cmd, cmd.Start))
+
+	line, err := bufio.NewReader(stdout).ReadString('\n')
+	require.NoError(t, err)
+	grandchild, err := strconv.Atoi(strings.TrimSpace(line))
+	require.NoError(t, err)
+
+	exited := make(chan struct{})
+	require.NoError(t, watchExit(grandchild, func() {
+		close(exited)
+	}))
+
+	select {
+	case <-exited:
+	case <-time.After(5 * time.Second):
+		require.Fail(t, "exit is not reported")
+	}
+
+	_, err = waitProcess(cmd.Process)
+	require.NoError(t, err)
+}
+
+func TestOnExitMany(t *testing.T) {
+	const count = 100
+
+	var wg sync.WaitGroup
+	for i := 0; i < count; i++ {
+		cmd := exec.Command("/bin/sh", "-c", fmt.Sprintf("exit %d", i))
+		cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+		require.NoError(t, startCommand(cmd, cmd.Start))
+
+		wg.Add(1)
+		onExit(cmd.Process, true, func(exit *processExit, err error) {
+			defer wg.Done()
+			assert.NoError(t, err)
+			assert.Equal(t, i, exit.ExitCode())
+		})
+	}
+
+	wg.Wait()
+}
@@ -0,0 +1,196 @@
+package containerd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"syscall"
+
+	"github.com/containerd/errdefs"
+	"github.com/containerd/errdefs/pkg/errgrpc"
+)
+
+const (
+	// maxSymlinks is the limit of symlinks followed while resolving a path, same as Linux MAXSYMLINKS
+	maxSymlinks = 40
+
+	// defaultPath is PATH of processes whose environment sets none, same as the default of containerd
+	defaultPath = "/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin"
+)
+
+// rootfsPath resolves unsafePath inside rootfs the same way it is resolved by a chrooted process,
+// so symlinks can't point outside of rootfs. The path doesn't have to exist.
+func rootfsPath(rootfs, unsafePath string) (string, error) {
+	current := "/"
+	remaining := unsafePath
+	links := 0
+
+	for remaining != "" {
+		var part string
+		part, remaining, _ = strings.Cut(remaining, "/")
+
+		switch part {
+		case "", ".":
+			continue
+		case "..":
+			current = filepath.Dir(current)
+			continue
+		}
+
+		next := filepath.Join(current, part)
+
+		stat, err := os.Lstat(filepath.Join(rootfs, next))
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				current = next
+				continue
+			}
+			return "", err
+		}
+
+		if stat.Mode()&os.ModeSymlink == 0 {
+			current = next
+			continue
+		}
+
+		links++
+		if links > maxSymlinks {
+			return "", fmt.Errorf("%s: %w", unsafePath, syscall.ELOOP)
+		}
+
+		target, err := os.Readlink(filepath.Join(rootfs, next))
+		if err != nil {
+			return "", err
+		}
+
+		if filepath.IsAbs(target) {
+			current = "/"
+		}
+
+		remaining = target + "/" + remaining
+	}
+
+	return filepath.Join(rootfs, current), nil
+}
+
+// prepareWorkingDir checks that cwd of a process is absolute and creates it inside rootfs if it is missing.
+// Like runc does, created directories are owned by the process user. Empty cwd is the root directory.
+func prepareWorkingDir(rootfs, cwd string, u *execUser) error {
+	if cwd == "" {
+		return nil
+	}
+
+	if !filepath.IsAbs(cwd) {
+		return errgrpc.ToGRPCf(errdefs.ErrInvalidArgument, "process cwd %q must be an absolute path", cwd)
+	}
+
+	resolved, err := rootfsPath(rootfs, cwd)
+	if err != nil {
+		return errgrpc.ToGRPCf(errdefs.ErrInvalidArgument, "working directory %q can't be resolved in container: %v", cwd, unwrapPathError(err))
+	}
+
+	// Collect missing directories from the innermost one, rootfsPath has already resolved symlinks of existing ones
+	var missing []string
+	for dir := resolved; ; dir = filepath.Dir(dir) {
+		stat, err := os.Stat(dir)
+		if err == nil {
+			if !stat.IsDir() {
+				return errgrpc.ToGRPCf(errdefs.ErrInvalidArgument, "working directory %q is not a directory in container", cwd)
+			}
+			break
+		}
+		if !errors.Is(err, os.ErrNotExist) || dir == rootfs {
+			return errgrpc.ToGRPCf(errdefs.ErrFailedPrecondition, "working directory %q can't be accessed in container: %v", cwd, unwrapPathError(err))
+		}
+		missing = append(missing, dir)
+	}
+
+	for _, dir := range slices.Backward(missing) {
+		err := os.Mkdir(dir, 0o755)
+		if err == nil {
+			// Processes of a running container may replace the directory with a symlink, it is not followed
+			err = os.Lchown(dir, int(u.uid), int(u.gid))
+		}
+		if err != nil {
+			return errgrpc.ToGRPCf(errdefs.ErrFailedPrecondition, "working directory %q doesn't exist in container and can't be created: %v", cwd, unwrapPathError(err))
+		}
+	}
+
+	return nil
+}
+
+// unwrapPathError drops the host path from errors of os functions
+func unwrapPathError(err error) error {
+	var pathErr *os.PathError
+	if errors.As(err, &pathErr) {
+		return pathErr.Err
+	}
+	return err
+}
+
+// lookPath resolves executable file of a process inside rootfs, like exec.LookPath in a chrooted process.
+// Files without a slash are searched for in PATH directories of env, relative files with a slash are relative to cwd.
+// It returns the path of the executable inside rootfs.
+func lookPath(rootfs, file, cwd string, env []string) (string, error) {
+	if strings.Contains(file, "/") {
+		path := file
+		if !filepath.IsAbs(path) {
+			path = filepath.Join("/", cwd, path)
+		}
+
+		if err := checkExecutable(rootfs, path); err != nil {
+			return "", errgrpc.ToGRPCf(errdefs.ErrInvalidArgument, "executable %q not found in container: %v", file, err)
+		}
+
+		return file, nil
+	}
+
+	pathEnv := defaultPath
+	for _, kv := range env {
+		if value, ok := strings.CutPrefix(kv, "PATH="); ok {
+			pathEnv = value
+		}
+	}
+
+	for _, dir := range filepath.SplitList(pathEnv) {
+		// Relative directories depend on the working directory, exec.LookPath refuses them as well
+		if !filepath.IsAbs(dir) {
+			continue
+		}
+
+		candidate := filepath.Join(dir, file)
+		if checkExecutable(rootfs, candidate) == nil {
+			return candidate, nil
+		}
+	}
+
+	// The message contains exec.ErrNotFound text, Docker reports exit code 127 for it
+	return "", errgrpc.ToGRPCf(errdefs.ErrInvalidArgument, "executable %q not found in container: %v in %s", file, exec.ErrNotFound, pathEnv)
+}
+
+// checkExecutable returns an error if path inside rootfs is not an executable file.
+// Errors don't mention host paths.
+func checkExecutable(rootfs, path string) error {
+	resolved, err := rootfsPath(rootfs, path)
+	if err != nil {
+		return err
+	}
+
+	stat, err := os.Stat(resolved)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		return os.ErrNotExist
+	case err != nil:
+		return err
+	case stat.IsDir():
+		return syscall.EISDIR
+	case !stat.Mode().IsRegular() || stat.Mode()&0o111 == 0:
+		return os.ErrPermission
+	}
+
+	return nil
+}
@@ -0,0 +1,272 @@
+package containerd
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	taskAPI "github.com/containerd/containerd/api/runtime/task/v3"
+	"github.com/containerd/errdefs"
+	"github.com/containerd/errdefs/pkg/errgrpc"
+	"github.com/containerd/typeurl/v2"
+	"github.com/darwin-containers/rund/internal/testutil"
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/stretchr/testify/require"
+)
+
+func writeExecutable(t *testing.T, path string) {
+	writeFile(t, path, "#!/bin/sh\n")
+	require.NoError(t, os.Chmod(path, 0o755))
+}
+
+func TestLookPath(t *testing.T) {
+	rootfs := t.TempDir()
+	writeExecutable(t, filepath.Join(rootfs, "usr", "bin", "python3"))
+	writeExecutable(t, filepath.Join(rootfs, "opt", "tools", "python3"))
+	writeExecutable(t, filepath.Join(rootfs, "app", "run.sh"))
+	writeFile(t, filepath.Join(rootfs, "usr", "local", "bin", "python3"), "not executable")
+	require.NoError(t, os.MkdirAll(filepath.Join(rootfs, "bin", "python3"), 0o755))
+	// Absolute symlinks resolve inside rootfs, not on the host
+	require.NoError(t, os.Symlink("/opt/tools", filepath.Join(rootfs, "tools")))
+
+	for _, tc := range []struct {
+		name     string
+		file     string
+		cwd      string
+		env      []string
+		expected string
+		err      string
+	}{
+		{
+			name:     "default PATH skips non-executables",
+			file:     "python3",
+			expected: "/usr/bin/python3",
+		},
+		{
+			name:     "PATH of the process",
+			file:     "python3",
+			env:      []string{"PATH=/missing:relative:/tools:/usr/bin"},
+			expected: "/tools/python3",
+		},
+		{
+			name:     "last PATH wins",
+			file:     "python3",
+			env:      []string{"PATH=/missing", "PATH=/usr/bin"},
+			expected: "/usr/bin/python3",
+		},
+		{
+			name: "not in PATH",
+			file: "python3",
+			env:  []string{"PATH=/bin:/sbin"},
+			err:  `executable "python3" not found in container: executable file not found in $PATH in /bin:/sbin`,
+		},
+		{
+			name: "host binaries are not found",
+			file: "go",
+			err:  `executable "go" not found in container`,
+		},
+		{
+			name:     "absolute path",
+			file:     "/opt/tools/python3",
+			expected: "/opt/tools/python3",
+		},
+		{
+			name:     "path relative to cwd",
+			file:     "./run.sh",
+			cwd:      "/app",
+			expected: "./run.sh",
+		},
+		{
+			name: "missing absolute path",
+			file: "/usr/bin/node",
+			err:  `executable "/usr/bin/node" not found in container: file does not exist`,
+		},
+		{
+			name: "directory",
+			file: "/bin/python3",
+			err:  "is a directory",
+		},
+		{
+			name: "not executable",
+			file: "/usr/local/bin/python3",
+			err:  "permission denied",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			executable, err := lookPath(rootfs, tc.file, tc.cwd, tc.env)
+			if tc.err != "" {
+				require.True(t, errdefs.IsInvalidArgument(errgrpc.ToNative(err)), err)
+				require.ErrorContains(t, err, tc.err)
+				require.NotContains(t, err.Error(), rootfs, "errors don't mention host paths")
+				return
+			}
+
+			require.NoError(t, err)
+			require.Equal(t, tc.expected, executable)
+		})
+	}
+}
+
+func TestExecutableInRootfs(t *testing.T) {
+	h := newTestHarness(t)
+
+	h.create("test", &specs.Spec{Process: testProcess("sleep", "60")})
+	h.start("test", "")
+
+	c, err := h.service.getContainerL("test")
+	require.NoError(t, err)
+
+	// The executable exists only in the container and is found in PATH of the exec process
+	hello := filepath.Join(c.rootfs, "opt", "tools", "hello")
+	writeFile(t, hello, "#!/bin/sh\necho hello from rootfs\n")
+	require.NoError(t, os.Chmod(hello, 0o755))
+
+	process := testProcess("hello")
+	process.Env = []string{"PATH=/opt/tools:/usr/bin:/bin"}
+	h.exec("test", "hello", process)
+	output, status := h.run("test", "hello")
+	require.Zero(t, status)
+	require.Equal(t, "hello from rootfs\n", output)
+
+	spec, err := typeurl.MarshalAnyToProto(testProcess("missing-binary"))
+	require.NoError(t, err)
+	_, err = h.service.Exec(h.ctx, &taskAPI.ExecProcessRequest{ID: "test", ExecID: "missing", Spec: spec})
+	require.True(t, errdefs.IsInvalidArgument(errgrpc.ToNative(err)), err)
+	require.ErrorContains(t, err, `executable "missing-binary" not found in container`)
+
+	h.delete("test", "hello")
+	h.kill("test", "", syscall.SIGKILL)
+	h.wait("test", "")
+	h.delete("test", "")
+
+	// The primary process is looked up the same way
+	_, err = h.service.Create(h.ctx, &taskAPI.CreateTaskRequest{
+		ID:     "missing",
+		Bundle: testutil.NewBundle(t, &specs.Spec{Process: testProcess("missing-binary")}),
+	})
+	require.True(t, errdefs.IsInvalidArgument(errgrpc.ToNative(err)), err)
+}
+
+func TestPrepareWorkingDir(t *testing.T) {
+	rootfs := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(rootfs, "opt", "app"), 0o755))
+	writeFile(t, filepath.Join(rootfs, "etc", "hostname"), "test")
+	// Absolute symlinks resolve inside rootfs, not on the host
+	require.NoError(t, os.Symlink("/opt", filepath.Join(rootfs, "home")))
+
+	u := &execUser{uid: uint32(os.Getuid()), gid: uint32(os.Getgid())}
+
+	for _, tc := range []struct {
+		name    string
+		cwd     string
+		created string
+		err     string
+	}{
+		{
+			name: "empty",
+		},
+		{
+			name: "existing",
+			cwd:  "/opt/app",
+		},
+		{
+			name:    "missing",
+			cwd:     "/work/src",
+			created: "work/src",
+		},
+		{
+			name:    "missing behind symlink",
+			cwd:     "/home/user",
+			created: "opt/user",
+		},
+		{
+			name: "relative",
+			cwd:  "work",
+			err:  `process cwd "work" must be an absolute path`,
+		},
+		{
+			name: "file",
+			cwd:  "/etc/hostname",
+			err:  `working directory "/etc/hostname" is not a directory in container`,
+		},
+		{
+			name: "below file",
+			cwd:  "/etc/hostname/dir",
+			err:  `working directory "/etc/hostname/dir"`,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			err := prepareWorkingDir(rootfs, tc.cwd, u)
+			if tc.err != "" {
+				require.Error(t, err)
+				require.ErrorContains(t, err, tc.err)
+				require.NotContains(t, err.Error(), rootfs, "errors don't mention host paths")
+				return
+			}
+
+			require.NoError(t, err)
+			if tc.created != "" {
+				stat, err := os.Stat(filepath.Join(rootfs, tc.created))
+				require.NoError(t, err)
+				require.True(t, stat.IsDir())
+				require.Equal(t, os.FileMode(0o755), stat.Mode().Perm())
+			}
+		})
+	}
+}
+
+func TestWorkingDirInRootfs(t *testing.T) {
+	h := newTestHarness(t)
+
+	// Missing working directory is created inside rootfs before the process starts
+	process := testProcess("pwd")
+	process.Cwd = "/work/src"
+	h.create("test", &specs.Spec{Process: process})
+
+	c, err := h.service.getContainerL("test")
+	require.NoError(t, err)
+
+	stat, err := os.Stat(filepath.Join(c.rootfs, "work", "src"))
+	require.NoError(t, err)
+	require.True(t, stat.IsDir())
+
+	output, status := h.run("test", "")
+	require.Zero(t, status)
+	require.Equal(t, "/work/src\n", output)
+
+	h.delete("test", "")
+
+	process = testProcess("pwd")
+	process.Cwd = "work"
+	_, err = h.service.Create(h.ctx, &taskAPI.CreateTaskRequest{
+		ID:     "relative",
+		Bundle: testutil.NewBundle(t, &specs.Spec{Process: process}),
+	})
+	require.True(t, errdefs.IsInvalidArgument(errgrpc.ToNative(err)), err)
+	require.ErrorContains(t, err, `process cwd "work" must be an absolute path`)
+}
+
+func TestExecutableInMount(t *testing.T) {
+	h := newTestHarness(t)
+
+	tools := t.TempDir()
+	hello := filepath.Join(tools, "hello")
+	writeFile(t, hello, "#!/bin/sh\necho hello from mount\n")
+	require.NoError(t, os.Chmod(hello, 0o755))
+
+	// The executable and working directory are resolved once mounts are in place
+	process := testProcess("hello")
+	process.Env = []string{"PATH=/opt/tools:/usr/bin:/bin"}
+	process.Cwd = "/opt/tools"
+	h.create("test", &specs.Spec{
+		Process: process,
+		Mounts:  []specs.Mount{{Type: "bind", Source: tools, Destination: "/opt/tools", Options: []string{"rbind", "ro"}}},
+	})
+
+	output, status := h.run("test", "")
+	require.Zero(t, status)
+	require.Equal(t, "hello from mount\n", output)
+
+	h.delete("test", "")
+}
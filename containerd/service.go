@@ -3,10 +3,12 @@ package containerd
 import (
 	"context"
 	"fmt"
+	"maps"
 	"net"
 	"os"
 	"path"
 	"path/filepath"
+	"slices"
 	"sync"
 	"syscall"
 	"time"
@@ -16,8 +18,6 @@ import (
 	"github.com/containerd/containerd/api/types"
 	"github.com/containerd/containerd/api/types/task"
 	"github.com/containerd/containerd/v2/core/mount"
-	"github.com/containerd/containerd/v2/core/runtime"
-	"github.com/containerd/containerd/v2/pkg/namespaces"
 	"github.com/containerd/containerd/v2/pkg/oci"
 	"github.com/containerd/containerd/v2/pkg/protobuf"
 	ptypes "github.com/containerd/containerd/v2/pkg/protobuf/types"
@@ -30,36 +30,34 @@ import (
 	"github.com/containerd/typeurl/v2"
 	"github.com/creack/pty"
 	"github.com/opencontainers/runtime-spec/specs-go"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 func NewTaskService(ctx context.Context, publisher shim.Publisher, sd shutdown.Service) (taskAPI.TTRPCTaskService, error) {
 	s := service{
 		containers: make(map[string]*container),
 		sd:         sd,
-		events:     make(chan interface{}, 128),
+		events:     newEventQueue(),
+		logs:       hookContainerLogs(),
+		metrics:    &metrics{},
 	}
 
-	go s.forward(ctx, publisher)
+	go s.events.forward(ctx, publisher)
 	return &s, nil
 }
 
 type service struct {
-	mu         sync.Mutex
+	// mu guards containers map and is held only for lookups and modifications of the map.
+	// Container operations are synchronized by container locks.
+	mu sync.Mutex
+	// containers maps ids to containers, nil value reserves the id of a container that is being created
 	containers map[string]*container
-	events     chan interface{}
-	sd         shutdown.Service
-}
-
-func (s *service) forward(ctx context.Context, publisher shim.Publisher) {
-	ns, _ := namespaces.Namespace(ctx)
-	ctx = namespaces.WithNamespace(context.Background(), ns)
-	for e := range s.events {
-		err := publisher.Publish(ctx, runtime.GetTopic(e), e)
-		if err != nil {
-			log.G(ctx).WithError(err).Error("post event")
-		}
-	}
-	_ = publisher.Close()
+	// shuttingDown is set by Shutdown, no containers are created then
+	shuttingDown bool
+	events       *eventQueue
+	logs         *containerLogs
+	metrics      *metrics
+	sd           shutdown.Service
 }
 
 func (s *service) getContainer(id string) (*container, error) {
@@ -77,15 +75,30 @@ func (s *service) getContainerL(id string) (*container, error) {
 	return s.getContainer(id)
 }
 
+// listContainers returns created containers sorted by id.
+func (s *service) listContainers() []*container {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var containers []*container
+	for _, id := range slices.Sorted(maps.Keys(s.containers)) {
+		if c := s.containers[id]; c != nil {
+			containers = append(containers, c)
+		}
+	}
+
+	return containers
+}
+
 func (s *service) RegisterTTRPC(server *ttrpc.Server) error {
 	taskAPI.RegisterTTRPCTaskService(server, s)
 	return nil
 }
 
 func (s *service) State(ctx context.Context, request *taskAPI.StateRequest) (resp *taskAPI.StateResponse, err error) {
-	log.G(ctx).WithField("request", request).Info("STATE")
+	_, done := startRPC(ctx, "State", request.ID, request.ExecID, request)
 	defer func() {
-		log.G(ctx).WithError(err).Info("STATE_DONE")
+		done(err)
 	}()
 
 	c, err := s.getContainerL(request.ID)
@@ -98,30 +111,27 @@ func (s *service) State(ctx context.Context, request *taskAPI.StateRequest) (res
 		return nil, err
 	}
 
-	var pid int
-	if process := p.cmd.Process; process != nil {
-		pid = process.Pid
-	}
+	st := p.state.get()
 
 	return &taskAPI.StateResponse{
 		ID:         request.ID,
 		Bundle:     c.bundlePath,
-		Pid:        uint32(pid),
-		Status:     p.status,
+		Pid:        uint32(st.pid),
+		Status:     st.status,
 		Stdin:      p.io.stdinPath,
 		Stdout:     p.io.stdoutPath,
 		Stderr:     p.io.stderrPath,
 		Terminal:   c.spec.Process.Terminal,
-		ExitedAt:   protobuf.ToTimestamp(p.exitedAt),
-		ExitStatus: p.exitStatus,
+		ExitedAt:   protobuf.ToTimestamp(st.exitedAt),
+		ExitStatus: st.exitStatus,
 		ExecID:     request.ExecID,
 	}, nil
 }
 
 func (s *service) Create(ctx context.Context, request *taskAPI.CreateTaskRequest) (_ *taskAPI.CreateTaskResponse, retErr error) {
-	log.G(ctx).WithField("request", request).Info("CREATE")
+	ctx, done := startRPC(ctx, "Create", request.ID, "", request)
 	defer func() {
-		log.G(ctx).WithError(retErr).Info("CREATE_DONE")
+		done(retErr)
 	}()
 
 	spec, err := oci.ReadSpec(path.Join(request.Bundle, oci.ConfigFilename))
@@ -129,6 +139,25 @@ func (s *service) Create(ctx context.Context, request *taskAPI.CreateTaskRequest
 		return nil, err
 	}
 
+	options, err := decodeOptions(request.Options)
+	if err != nil {
+		return nil, err
+	}
+
+	config, err := parseAnnotations(spec.Annotations)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = options.checkSockets(config.sockets); err != nil {
+		return nil, err
+	}
+
+	mode := config.validationMode(validationMode(options.ValidationMode))
+	if err = checkUnsupported(ctx, mode, append(validateSpec(spec), validateRootfs(request.Rootfs)...)); err != nil {
+		return nil, err
+	}
+
 	rootfs, err := mount.CanonicalizePath(spec.Root.Path)
 	if err != nil {
 		return nil, err
@@ -140,49 +169,130 @@ func (s *service) Create(ctx context.Context, request *taskAPI.CreateTaskRequest
 		return nil, err
 	}
 
-	dnsSocketPath := path.Join(shortenedRootfsPath, "var", "run", "mDNSResponder")
+	// mDNSResponder socket is forwarded unless annotations forward another socket to its path
+	forwards := config.sockets
+	if !slices.ContainsFunc(forwards, func(f socketForward) bool { return f.path == mDNSResponderSocket }) {
+		forwards = append([]socketForward{{path: mDNSResponderSocket, hostPath: options.MDNSResponderPath}}, forwards...)
+	}
+
+	var sockets []*forwardedSocket
+	for _, f := range forwards {
+		sockets = append(sockets, &forwardedSocket{socketForward: f})
+	}
 
 	s.mu.Lock()
-	defer s.mu.Unlock()
+	if s.shuttingDown {
+		s.mu.Unlock()
+		return nil, errgrpc.ToGRPCf(errdefs.ErrFailedPrecondition, "shim is shutting down")
+	}
+	if _, ok := s.containers[request.ID]; ok {
+		s.mu.Unlock()
+		return nil, errgrpc.ToGRPCf(errdefs.ErrAlreadyExists, "container already exists: %s", request.ID)
+	}
+	s.containers[request.ID] = nil
+	s.mu.Unlock()
+
+	defer func() {
+		if retErr != nil {
+			s.mu.Lock()
+			delete(s.containers, request.ID)
+			s.mu.Unlock()
+		}
+	}()
+
+	if err = s.logs.open(request.ID, request.Bundle, config.logFile); err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		if retErr != nil {
+			s.logs.close(request.ID)
+		}
+	}()
 
 	c := &container{
-		spec:          spec,
-		bundlePath:    request.Bundle,
-		rootfs:        rootfs,
-		dnsSocketPath: dnsSocketPath,
-		primary: managedProcess{
-			spec:      spec.Process,
-			waitblock: make(chan struct{}),
-			status:    task.Status_CREATED,
-		},
-		auxiliary: make(map[string]*managedProcess),
+		id:         request.ID,
+		spec:       spec,
+		bundlePath: request.Bundle,
+		rootfs:     rootfs,
+		sockets:    sockets,
+		primary:    newManagedProcess(spec.Process, true, config.limits),
+		auxiliary:  make(map[string]*managedProcess),
+		options:    options,
+		config:     config,
+		metrics:    s.metrics,
+		created:    time.Now(),
 	}
 
 	defer func() {
 		if retErr != nil {
-			if err := c.destroy(); err != nil {
+			if err := c.destroy(ctx); err != nil {
 				log.G(ctx).WithError(err).Warn("failed to cleanup container")
 			}
 		}
 	}()
 
-	if err = c.primary.setup(ctx, c.rootfs, request.Stdin, request.Stdout, request.Stderr); err != nil {
-		return nil, err
+	var mountTime time.Duration
+	mountAll := func(rootfs []*types.Mount, specMounts []specs.Mount) error {
+		var mounts []mount.Mount
+		err := traced(ctx, "processMounts", func(context.Context) (err error) {
+			mounts, err = processMounts(c.rootfs, rootfs, specMounts)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+
+		c.mounts = append(c.mounts, mounts...)
+
+		start := time.Now()
+		err = traced(ctx, "mount.All", func(context.Context) error {
+			return mount.All(mounts, c.rootfs)
+		})
+		mountTime += time.Since(start)
+		if err != nil {
+			return fmt.Errorf("failed to mount rootfs component: %w", err)
+		}
+
+		return nil
 	}
 
-	mounts, err := processMounts(c.rootfs, request.Rootfs, spec.Mounts)
+	err = mountAll(request.Rootfs, nil)
+	defer func() {
+		s.metrics.mount.observe(mountTime)
+	}()
 	if err != nil {
 		return nil, err
 	}
 
-	if err = mount.All(mounts, c.rootfs); err != nil {
-		return nil, fmt.Errorf("failed to mount rootfs component: %w", err)
+	// Checkpoint is restored before mounts of the spec are mounted,
+	// so removing and replacing entries of the rootfs can't reach files of their sources
+	if request.Checkpoint != "" {
+		err = traced(ctx, "restoreCheckpoint", func(context.Context) error {
+			return restoreCheckpoint(c.rootfs, request.Checkpoint)
+		})
+		if err != nil {
+			return nil, err
+		}
 	}
 
-	// TODO: Check if container already exists?
-	s.containers[request.ID] = c
+	if err = mountAll(nil, spec.Mounts); err != nil {
+		return nil, err
+	}
+
+	// Sockets are resolved in the mounted rootfs, so that symlinks of the image can't point them to the host
+	for _, socket := range c.sockets {
+		if socket.listenPath, err = socketListenPath(c.rootfs, shortenedRootfsPath, socket.path); err != nil {
+			return nil, err
+		}
+	}
 
-	s.events <- &events.TaskCreate{
+	// User, executable and working directory of the process are resolved in the mounted rootfs
+	if err = c.primary.setup(ctx, c.rootfs, c.defaultArgs(), request.Stdin, request.Stdout, request.Stderr); err != nil {
+		return nil, err
+	}
+
+	s.events.send(&events.TaskCreate{
 		ContainerID: request.ID,
 		Bundle:      c.bundlePath,
 		Rootfs:      request.Rootfs,
@@ -193,7 +303,11 @@ func (s *service) Create(ctx context.Context, request *taskAPI.CreateTaskRequest
 			Terminal: c.spec.Process.Terminal,
 		},
 		Checkpoint: request.Checkpoint,
-	}
+	})
+
+	s.mu.Lock()
+	s.containers[request.ID] = c
+	s.mu.Unlock()
 
 	return &taskAPI.CreateTaskResponse{}, nil
 }
@@ -212,6 +326,21 @@ func shortenPath(p string) (string, error) {
 	return shortened, nil
 }
 
+// socketListenPath resolves path of a socket inside rootfs and returns it relative to shortenedRootfs.
+func socketListenPath(rootfs, shortenedRootfs, socket string) (string, error) {
+	resolved, err := rootfsPath(rootfs, socket)
+	if err != nil {
+		return "", errgrpc.ToGRPCf(errdefs.ErrInvalidArgument, "socket %q can't be resolved in container: %v", socket, unwrapPathError(err))
+	}
+
+	rel, err := filepath.Rel(rootfs, resolved)
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(shortenedRootfs, rel), nil
+}
+
 func processMounts(targetRoot string, rootfs []*types.Mount, specMounts []specs.Mount) ([]mount.Mount, error) {
 	var mounts []mount.Mount
 	for _, m := range rootfs {
@@ -247,29 +376,93 @@ func processMount(rootfs, mtype, source, target string, options []string) (*moun
 		Options: options,
 	}
 
-	switch mtype {
-	case "bind":
+	if !slices.Contains(mountTypes, mtype) {
+		log.L.Warn("skipping mount: ", m)
+		return nil, nil
+	}
+
+	if mtype == mountTypeBind {
 		stat, err := os.Stat(source)
 		if err != nil {
 			return nil, err
 		}
 
-		if stat.IsDir() {
-			fullPath := filepath.Join(rootfs, target)
-			if err = os.MkdirAll(fullPath, 0o755); err != nil {
-				return nil, err
-			}
-
-			return m, nil
-		} else {
+		if !stat.IsDir() {
 			// skip, only dirs are supported by bindfs
+			log.L.Warn("skipping mount: ", m)
+			return nil, nil
 		}
-	case "devfs":
-		return m, nil
+
+		fullPath := filepath.Join(rootfs, target)
+		if err = os.MkdirAll(fullPath, 0o755); err != nil {
+			return nil, err
+		}
+	}
+
+	return m, nil
+}
+
+// forwardSocket listens on the socket in the container and forwards its connections to the host socket.
+// The proxy outlives ctx.
+func (s *service) forwardSocket(ctx context.Context, c *container, socket *forwardedSocket) error {
+	if err := os.MkdirAll(path.Dir(socket.listenPath), 0o755); err != nil {
+		return err
+	}
+
+	var lc net.ListenConfig
+	listener, err := lc.Listen(ctx, "unix", socket.listenPath)
+	if err != nil {
+		return err
 	}
 
-	log.L.Warn("skipping mount: ", m)
-	return nil, nil
+	unixListener := listener.(*net.UnixListener)
+	if unixListener == nil {
+		_ = listener.Close()
+		return fmt.Errorf("not a unix socket: %s", listener)
+	}
+	socket.listener = listener
+
+	go s.proxySocket(context.WithoutCancel(ctx), unixListener, socket.hostPath, &c.proxy)
+
+	return nil
+}
+
+// proxySocket forwards connections accepted by listener to the host socket at hostPath until listener is closed.
+func (s *service) proxySocket(ctx context.Context, listener *net.UnixListener, hostPath string, stats *proxyStats) {
+	for {
+		con, err := listener.AcceptUnix()
+		if err != nil {
+			return
+		}
+
+		var dialer net.Dialer
+		pipe, err := dialer.DialContext(ctx, "unix", hostPath)
+		if err != nil {
+			_ = con.Close()
+			return
+		}
+
+		unixPipe := pipe.(*net.UnixConn)
+		if unixPipe == nil {
+			_ = con.Close()
+			_ = pipe.Close()
+			return
+		}
+
+		stats.active.Add(1)
+		stats.total.Add(1)
+		s.metrics.proxyConnections.Add(1)
+
+		go unixSocketCopy(unixPipe, con)
+		go func() {
+			_ = unixSocketCopy(con, unixPipe)
+
+			// Client is gone, unblock the copy in the opposite direction
+			_ = con.Close()
+			_ = unixPipe.Close()
+			stats.active.Add(-1)
+		}()
+	}
 }
 
 func unixSocketCopy(from, to *net.UnixConn) error {
@@ -289,86 +482,73 @@ func unixSocketCopy(from, to *net.UnixConn) error {
 }
 
 func (s *service) Start(ctx context.Context, request *taskAPI.StartRequest) (resp *taskAPI.StartResponse, err error) {
-	log.G(ctx).WithField("request", request).Info("START")
+	ctx, done := startRPC(ctx, "Start", request.ID, request.ExecID, request)
 	defer func() {
-		log.G(ctx).WithError(err).Info("START_DONE")
+		done(err)
 	}()
 
-	s.mu.Lock()
-	defer s.mu.Unlock()
-
-	c, err := s.getContainer(request.ID)
+	c, err := s.getContainerL(request.ID)
 	if err != nil {
 		return nil, err
 	}
 
-	c.mu.Lock()
-	defer c.mu.Unlock()
-
-	if request.ExecID == "" {
-		if err = os.MkdirAll(path.Dir(c.dnsSocketPath), 0o755); err != nil {
-			return nil, err
-		}
+	if err = c.lock(); err != nil {
+		return nil, err
+	}
+	defer c.unlock()
 
-		var lc net.ListenConfig
-		dnsSocket, err := lc.Listen(ctx, "unix", c.dnsSocketPath)
-		if err != nil {
-			return nil, err
-		}
+	p, err := c.getProcessL(request.ExecID)
+	if err != nil {
+		return nil, err
+	}
 
-		unixSocket := dnsSocket.(*net.UnixListener)
-		if unixSocket == nil {
-			_ = dnsSocket.Close()
-			return nil, fmt.Errorf("not a unix socket: %s", dnsSocket)
-		}
+	if err = p.state.require("start", task.Status_CREATED); err != nil {
+		return nil, err
+	}
 
-		go func() {
-			for {
-				con, err := unixSocket.AcceptUnix()
-				if err != nil {
-					return
-				}
-
-				var dialer net.Dialer
-				pipe, err := dialer.DialContext(ctx, "unix", "/var/run/mDNSResponder")
-				if err != nil {
-					return
-				}
-
-				unixPipe := pipe.(*net.UnixConn)
-				if unixPipe == nil {
-					_ = pipe.Close()
-					return
-				}
-
-				go unixSocketCopy(con, unixPipe)
-				go unixSocketCopy(unixPipe, con)
+	if request.ExecID == "" {
+		for _, socket := range c.sockets {
+			if err = s.forwardSocket(ctx, c, socket); err != nil {
+				return nil, err
 			}
-		}()
+		}
 	}
 
-	p, err := c.getProcess(request.ExecID)
-	if err != nil {
+	if err = p.start(); err != nil {
 		return nil, err
 	}
 
-	if err = p.start(); err != nil {
-		return nil, err
+	pid := p.cmd.Process.Pid
+
+	// Start event is sent before exit is subscribed to, so it always precedes the exit event
+	if request.ExecID == "" {
+		s.events.send(&events.TaskStart{
+			ContainerID: request.ID,
+			Pid:         uint32(pid),
+		})
+	} else {
+		s.events.send(&events.TaskExecStarted{
+			ContainerID: request.ID,
+			ExecID:      request.ExecID,
+			Pid:         uint32(pid),
+		})
 	}
 
-	go func() {
-		var w *os.ProcessState
+	// The span outlives the RPC and ends when the process, or its whole process group, exits
+	_, waitSpan := startSpan(ctx, "wait", attribute.Int("pid", pid))
 
-		if request.ExecID == "" {
-			w, _ = wait(p.cmd.Process)
+	onExit(p.cmd.Process, p.waitProcessGroup, func(w *processExit, err error) {
+		exitStatus := uint32(255)
+		if err != nil {
+			log.G(ctx).WithError(err).Error("failed to wait for process")
 		} else {
-			w, _ = p.cmd.Process.Wait()
+			exitStatus = w.ExitStatus()
 		}
 
-		p.exitedAt = time.Now()
-		p.exitStatus = uint32(w.ExitCode())
-		p.status = task.Status_STOPPED
+		waitSpan.SetAttributes(attribute.Int64("exit.status", int64(exitStatus)))
+		endSpan(waitSpan, err)
 
+		p.drainOutput()
 		_ = p.io.Close()
 
 		// Madness...
@@ -377,119 +557,183 @@ func (s *service) Start(ctx context.Context, request *taskAPI.StartRequest) (res
 			id = request.ExecID
 		}
 
-		s.events <- &events.TaskExit{
-			ContainerID: request.ID,
-			ID:          id,
-			Pid:         uint32(w.Pid()),
-			ExitedAt:    protobuf.ToTimestamp(p.exitedAt),
-			ExitStatus:  p.exitStatus,
-		}
-
-		close(p.waitblock)
-	}()
-
-	if request.ExecID == "" {
-		s.events <- &events.TaskStart{
-			ContainerID: request.ID,
-			Pid:         uint32(p.cmd.Process.Pid),
-		}
-	} else {
-		s.events <- &events.TaskExecStarted{
-			ContainerID: request.ID,
-			ExecID:      request.ExecID,
-			Pid:         uint32(p.cmd.Process.Pid),
-		}
-	}
+		// Exit event is sent and waiters are released together with recording the exit,
+		// so Delete and Wait that observe the process stopped always come after the exit event.
+		p.state.exit(exitStatus, time.Now(), func(st processStatus) {
+			s.events.send(&events.TaskExit{
+				ContainerID: request.ID,
+				ID:          id,
+				Pid:         uint32(pid),
+				ExitedAt:    protobuf.ToTimestamp(st.exitedAt),
+				ExitStatus:  st.exitStatus,
+			})
+
+			close(p.waitblock)
+		})
+	})
 
 	return &taskAPI.StartResponse{
-		Pid: uint32(p.cmd.Process.Pid),
+		Pid: uint32(pid),
 	}, nil
 }
 
 func (s *service) Delete(ctx context.Context, request *taskAPI.DeleteRequest) (resp *taskAPI.DeleteResponse, err error) {
-	log.G(ctx).WithField("request", request).Info("DELETE")
+	ctx, done := startRPC(ctx, "Delete", request.ID, request.ExecID, request)
 	defer func() {
-		log.G(ctx).WithError(err).Info("DELETE_DONE")
+		done(err)
 	}()
 
-	s.mu.Lock()
-	defer s.mu.Unlock()
-
-	c, err := s.getContainer(request.ID)
+	c, err := s.getContainerL(request.ID)
 	if err != nil {
 		return nil, err
 	}
 
-	if request.ExecID != "" {
-		c.mu.Lock()
-		defer c.mu.Unlock()
+	if err = c.lock(); err != nil {
+		return nil, err
+	}
+	defer c.unlock()
 
-		p, err := c.getProcess(request.ExecID)
+	if request.ExecID != "" {
+		p, err := c.getProcessL(request.ExecID)
 		if err != nil {
 			return nil, err
 		}
 
+		if err := p.state.require("delete", task.Status_CREATED, task.Status_STOPPED); err != nil {
+			return nil, err
+		}
+
 		if err := p.destroy(); err != nil {
 			log.G(ctx).WithError(err).Warn("failed to destroy exec")
 		}
+
+		c.mu.Lock()
 		delete(c.auxiliary, request.ExecID)
+		c.mu.Unlock()
+
+		st := p.state.get()
 
 		return &taskAPI.DeleteResponse{
-			ExitedAt:   protobuf.ToTimestamp(p.exitedAt),
-			ExitStatus: p.exitStatus,
+			ExitedAt:   protobuf.ToTimestamp(st.exitedAt),
+			ExitStatus: st.exitStatus,
+			Pid:        uint32(st.pid),
 		}, nil
 	}
 
-	if err := c.destroy(); err != nil {
+	if err := c.primary.state.require("delete", task.Status_CREATED, task.Status_STOPPED); err != nil {
+		return nil, err
+	}
+
+	if err := c.destroy(ctx); err != nil {
 		log.G(ctx).WithError(err).Warn("failed to cleanup container")
 	}
+	c.deleted = true
+	s.logs.close(request.ID)
 
+	s.mu.Lock()
 	delete(s.containers, request.ID)
+	s.mu.Unlock()
 
-	var pid uint32
-	if p := c.primary.cmd.Process; p != nil {
-		pid = uint32(p.Pid)
-	}
+	st := c.primary.state.get()
 
-	s.events <- &events.TaskDelete{
+	s.events.send(&events.TaskDelete{
 		ContainerID: request.ID,
-		ExitedAt:    protobuf.ToTimestamp(c.primary.exitedAt),
-		ExitStatus:  c.primary.exitStatus,
+		ExitedAt:    protobuf.ToTimestamp(st.exitedAt),
+		ExitStatus:  st.exitStatus,
 		ID:          request.ID,
-		Pid:         pid,
-	}
+		Pid:         uint32(st.pid),
+	})
 
 	return &taskAPI.DeleteResponse{
-		ExitedAt:   protobuf.ToTimestamp(c.primary.exitedAt),
-		ExitStatus: c.primary.exitStatus,
-		Pid:        pid,
+		ExitedAt:   protobuf.ToTimestamp(st.exitedAt),
+		ExitStatus: st.exitStatus,
+		Pid:        uint32(st.pid),
 	}, nil
 }
 
-func (s *service) Pids(ctx context.Context, request *taskAPI.PidsRequest) (*taskAPI.PidsResponse, error) {
-	log.G(ctx).WithField("request", request).Info("PIDS")
+func (s *service) Pids(ctx context.Context, request *taskAPI.PidsRequest) (_ *taskAPI.PidsResponse, err error) {
+	_, done := startRPC(ctx, "Pids", request.ID, "", request)
+	defer func() {
+		done(err)
+	}()
 	return nil, errdefs.ErrNotImplemented
 }
 
-func (s *service) Pause(ctx context.Context, request *taskAPI.PauseRequest) (*ptypes.Empty, error) {
-	log.G(ctx).WithField("request", request).Info("PAUSE")
-	return nil, errdefs.ErrNotImplemented
+func (s *service) Pause(ctx context.Context, request *taskAPI.PauseRequest) (resp *ptypes.Empty, err error) {
+	_, done := startRPC(ctx, "Pause", request.ID, "", request)
+	defer func() {
+		done(err)
+	}()
+
+	c, err := s.getContainerL(request.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = c.pause(); err != nil {
+		return nil, err
+	}
+
+	s.events.send(&events.TaskPaused{
+		ContainerID: request.ID,
+	})
+
+	return &ptypes.Empty{}, nil
 }
 
-func (s *service) Resume(ctx context.Context, request *taskAPI.ResumeRequest) (*ptypes.Empty, error) {
-	log.G(ctx).WithField("request", request).Info("RESUME")
-	return nil, errdefs.ErrNotImplemented
+func (s *service) Resume(ctx context.Context, request *taskAPI.ResumeRequest) (resp *ptypes.Empty, err error) {
+	_, done := startRPC(ctx, "Resume", request.ID, "", request)
+	defer func() {
+		done(err)
+	}()
+
+	c, err := s.getContainerL(request.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = c.resume(); err != nil {
+		return nil, err
+	}
+
+	s.events.send(&events.TaskResumed{
+		ContainerID: request.ID,
+	})
+
+	return &ptypes.Empty{}, nil
 }
 
-func (s *service) Checkpoint(ctx context.Context, request *taskAPI.CheckpointTaskRequest) (*ptypes.Empty, error) {
-	log.G(ctx).WithField("request", request).Info("CHECKPOINT")
-	return nil, errdefs.ErrNotImplemented
+func (s *service) Checkpoint(ctx context.Context, request *taskAPI.CheckpointTaskRequest) (_ *ptypes.Empty, err error) {
+	ctx, done := startRPC(ctx, "Checkpoint", request.ID, "", request)
+	defer func() {
+		done(err)
+	}()
+
+	if request.Path == "" {
+		return nil, errgrpc.ToGRPCf(errdefs.ErrInvalidArgument, "checkpoint path must not be empty")
+	}
+
+	c, err := s.getContainerL(request.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = c.checkpoint(ctx, request.Path); err != nil {
+		return nil, err
+	}
+
+	s.events.send(&events.TaskCheckpointed{
+		ContainerID: request.ID,
+		Checkpoint:  request.Path,
+	})
+
+	return &ptypes.Empty{}, nil
 }
 
 func (s *service) Kill(ctx context.Context, request *taskAPI.KillRequest) (resp *ptypes.Empty, err error) {
-	log.G(ctx).WithField("request", request).Info("KILL")
+	_, done := startRPC(ctx, "Kill", request.ID, request.ExecID, request)
 	defer func() {
-		log.G(ctx).WithError(err).Info("KILL_DONE")
+		done(err)
 	}()
 
 	c, err := s.getContainerL(request.ID)
@@ -502,14 +746,25 @@ func (s *service) Kill(ctx context.Context, request *taskAPI.KillRequest) (resp
 		return nil, err
 	}
 
-	// TODO: Do we care about error here?
-	_ = p.kill(syscall.Signal(request.Signal))
+	if err = p.kill(syscall.Signal(request.Signal)); err != nil {
+		return nil, err
+	}
+
+	// Stopped process handles no signal but SIGKILL until it is continued, so a paused container is resumed
+	if p.state.get().status == task.Status_PAUSED && c.resume() == nil {
+		s.events.send(&events.TaskResumed{
+			ContainerID: request.ID,
+		})
+	}
 
 	return &ptypes.Empty{}, nil
 }
 
 func (s *service) Exec(ctx context.Context, request *taskAPI.ExecProcessRequest) (_ *ptypes.Empty, retErr error) {
-	log.G(ctx).WithField("request", request).Info("EXEC")
+	ctx, done := startRPC(ctx, "Exec", request.ID, request.ExecID, request)
+	defer func() {
+		done(retErr)
+	}()
 
 	specAny, err := typeurl.UnmarshalAny(request.Spec)
 	if err != nil {
@@ -523,20 +778,47 @@ func (s *service) Exec(ctx context.Context, request *taskAPI.ExecProcessRequest)
 		return nil, errdefs.ErrInvalidArgument
 	}
 
+	log.G(ctx).WithField("process", redactProcess(spec)).Debug("exec process")
+
+	waitProcessGroup, err := takeWaitProcessGroup(spec)
+	if err != nil {
+		return nil, errgrpc.ToGRPCf(errdefs.ErrInvalidArgument, "%v", err)
+	}
+
 	c, err := s.getContainerL(request.ID)
 	if err != nil {
 		return nil, err
 	}
 
-	c.mu.Lock()
-	defer c.mu.Unlock()
+	if err = c.lock(); err != nil {
+		return nil, err
+	}
+	defer c.unlock()
 
-	aux := &managedProcess{
-		spec:      spec,
-		waitblock: make(chan struct{}),
-		status:    task.Status_CREATED,
+	if request.ExecID == "" {
+		return nil, errgrpc.ToGRPCf(errdefs.ErrInvalidArgument, "exec id must not be empty")
+	}
+
+	if _, ok := c.auxiliary[request.ExecID]; ok {
+		return nil, errgrpc.ToGRPCf(errdefs.ErrAlreadyExists, "exec already exists: %s", request.ExecID)
+	}
+
+	if err = c.primary.state.require("exec in", task.Status_RUNNING); err != nil {
+		return nil, err
+	}
+
+	// Exec processes are validated in the mode of their container, whose spec has been validated on create
+	mode := c.config.validationMode(validationMode(c.options.ValidationMode))
+	if err = checkUnsupported(ctx, mode, validateProcess("process", spec)); err != nil {
+		return nil, err
 	}
 
+	if c.config.execInheritEnv {
+		spec.Env = mergeEnv(c.primary.spec.Env, spec.Env)
+	}
+
+	aux := newManagedProcess(spec, waitProcessGroup, c.config.limits)
+
 	defer func() {
 		if retErr != nil {
 			if err := aux.destroy(); err != nil {
@@ -545,25 +827,26 @@ func (s *service) Exec(ctx context.Context, request *taskAPI.ExecProcessRequest)
 		}
 	}()
 
-	if err = aux.setup(ctx, c.rootfs, request.Stdin, request.Stdout, request.Stderr); err != nil {
+	if err = aux.setup(ctx, c.rootfs, c.defaultArgs(), request.Stdin, request.Stdout, request.Stderr); err != nil {
 		return nil, err
 	}
 
-	// TODO: Check if aux already exists?
+	c.mu.Lock()
 	c.auxiliary[request.ExecID] = aux
+	c.mu.Unlock()
 
-	s.events <- &events.TaskExecAdded{
+	s.events.send(&events.TaskExecAdded{
 		ContainerID: request.ID,
 		ExecID:      request.ExecID,
-	}
+	})
 
 	return &ptypes.Empty{}, nil
 }
 
 func (s *service) ResizePty(ctx context.Context, request *taskAPI.ResizePtyRequest) (resp *ptypes.Empty, err error) {
-	log.G(ctx).WithField("request", request).Info("RESIZEPTY")
+	_, done := startRPC(ctx, "ResizePty", request.ID, request.ExecID, request)
 	defer func() {
-		log.G(ctx).WithError(err).Info("RESIZEPTY_DONE")
+		done(err)
 	}()
 
 	c, err := s.getContainerL(request.ID)
@@ -585,8 +868,11 @@ func (s *service) ResizePty(ctx context.Context, request *taskAPI.ResizePtyReque
 	return &ptypes.Empty{}, nil
 }
 
-func (s *service) CloseIO(ctx context.Context, request *taskAPI.CloseIORequest) (*ptypes.Empty, error) {
-	log.G(ctx).WithField("request", request).Info("CLOSEIO")
+func (s *service) CloseIO(ctx context.Context, request *taskAPI.CloseIORequest) (_ *ptypes.Empty, err error) {
+	_, done := startRPC(ctx, "CloseIO", request.ID, request.ExecID, request)
+	defer func() {
+		done(err)
+	}()
 
 	c, err := s.getContainerL(request.ID)
 	if err != nil {
@@ -605,15 +891,18 @@ func (s *service) CloseIO(ctx context.Context, request *taskAPI.CloseIORequest)
 	return &ptypes.Empty{}, nil
 }
 
-func (s *service) Update(ctx context.Context, request *taskAPI.UpdateTaskRequest) (*ptypes.Empty, error) {
-	log.G(ctx).WithField("request", request).Info("UPDATE")
+func (s *service) Update(ctx context.Context, request *taskAPI.UpdateTaskRequest) (_ *ptypes.Empty, err error) {
+	_, done := startRPC(ctx, "Update", request.ID, "", request)
+	defer func() {
+		done(err)
+	}()
 	return nil, errdefs.ErrNotImplemented
 }
 
 func (s *service) Wait(ctx context.Context, request *taskAPI.WaitRequest) (resp *taskAPI.WaitResponse, err error) {
-	log.G(ctx).WithField("request", request).Info("WAIT")
+	_, done := startRPC(ctx, "Wait", request.ID, request.ExecID, request)
 	defer func() {
-		log.G(ctx).WithError(err).Info("WAIT_DONE")
+		done(err)
 	}()
 
 	c, err := s.getContainerL(request.ID)
@@ -628,28 +917,31 @@ func (s *service) Wait(ctx context.Context, request *taskAPI.WaitRequest) (resp
 
 	<-p.waitblock
 
+	st := p.state.get()
+
 	return &taskAPI.WaitResponse{
-		ExitedAt:   protobuf.ToTimestamp(p.exitedAt),
-		ExitStatus: p.exitStatus,
+		ExitedAt:   protobuf.ToTimestamp(st.exitedAt),
+		ExitStatus: st.exitStatus,
 	}, nil
 }
 
-func (s *service) Stats(ctx context.Context, request *taskAPI.StatsRequest) (*taskAPI.StatsResponse, error) {
-	log.G(ctx).WithField("request", request).Info("STATS")
+func (s *service) Stats(ctx context.Context, request *taskAPI.StatsRequest) (_ *taskAPI.StatsResponse, err error) {
+	_, done := startRPC(ctx, "Stats", request.ID, "", request)
+	defer func() {
+		done(err)
+	}()
 	return nil, errdefs.ErrNotImplemented
 }
 
 func (s *service) Connect(ctx context.Context, request *taskAPI.ConnectRequest) (resp *taskAPI.ConnectResponse, err error) {
-	log.G(ctx).WithField("request", request).Info("CONNECT")
+	_, done := startRPC(ctx, "Connect", request.ID, "", request)
 	defer func() {
-		log.G(ctx).WithError(err).Info("CONNECT_DONE")
+		done(err)
 	}()
 
 	var pid int
 	if c, err := s.getContainerL(request.ID); err == nil {
-		if p := c.primary.cmd.Process; p != nil {
-			pid = p.Pid
-		}
+		pid = c.primary.state.get().pid
 	}
 
 	return &taskAPI.ConnectResponse{
@@ -659,17 +951,23 @@ func (s *service) Connect(ctx context.Context, request *taskAPI.ConnectRequest)
 }
 
 func (s *service) Shutdown(ctx context.Context, request *taskAPI.ShutdownRequest) (resp *ptypes.Empty, err error) {
-	log.G(ctx).WithField("request", request).Info("SHUTDOWN")
+	ctx, done := startRPC(ctx, "Shutdown", request.ID, "", request)
 	defer func() {
-		log.G(ctx).WithError(err).Info("SHUTDOWN_DONE")
+		done(err)
 	}()
 
 	s.mu.Lock()
-	defer s.mu.Unlock()
-
 	if len(s.containers) > 0 {
+		s.mu.Unlock()
 		return &ptypes.Empty{}, nil
 	}
+	s.shuttingDown = true
+	s.mu.Unlock()
+
+	// Other RPCs are not blocked while pending events are flushed
+	if !s.events.close(flushTimeout) {
+		log.G(ctx).Warn("timed out publishing pending events")
+	}
 
 	s.sd.Shutdown()
 
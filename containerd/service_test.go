@@ -0,0 +1,473 @@
+package containerd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/containerd/containerd/api/events"
+	taskAPI "github.com/containerd/containerd/api/runtime/task/v3"
+	"github.com/containerd/containerd/api/types/task"
+	"github.com/containerd/containerd/v2/pkg/oci"
+	"github.com/containerd/errdefs"
+	"github.com/containerd/errdefs/pkg/errgrpc"
+	"github.com/containerd/typeurl/v2"
+	"github.com/darwin-containers/rund/internal/testutil"
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestService(t *testing.T, status task.Status) *service {
+	s := &service{
+		containers: make(map[string]*container),
+		events:     newEventQueue(),
+		logs:       newContainerLogs(),
+		metrics:    &metrics{},
+	}
+
+	// Exec requests run /bin/true that is looked up in rootfs
+	rootfs := t.TempDir()
+	writeExecutable(t, filepath.Join(rootfs, "bin", "true"))
+
+	s.containers["test"] = &container{
+		id:        "test",
+		spec:      &oci.Spec{Process: &specs.Process{}},
+		rootfs:    rootfs,
+		primary:   newManagedProcess(&specs.Process{}, true, processLimits{}),
+		auxiliary: make(map[string]*managedProcess),
+		options:   defaultOptions(),
+		config:    &containerConfig{},
+		metrics:   s.metrics,
+	}
+
+	s.containers["test"].primary.state.status = status
+
+	return s
+}
+
+func runningProcess() *managedProcess {
+	p := newManagedProcess(&specs.Process{}, true, processLimits{})
+	p.state.status = task.Status_RUNNING
+	return p
+}
+
+func execRequest(t *testing.T, execID string) *taskAPI.ExecProcessRequest {
+	spec, err := typeurl.MarshalAnyToProto(&specs.Process{
+		Args: []string{"/bin/true"},
+		Cwd:  "/",
+	})
+	require.NoError(t, err)
+
+	return &taskAPI.ExecProcessRequest{
+		ID:     "test",
+		ExecID: execID,
+		Spec:   spec,
+	}
+}
+
+func TestCreateAlreadyExists(t *testing.T) {
+	s := newTestService(t, task.Status_CREATED)
+	existing := s.containers["test"]
+
+	bundle := t.TempDir()
+	config, err := json.Marshal(&specs.Spec{
+		Process: &specs.Process{Args: []string{"/bin/true"}, Cwd: "/"},
+		Root:    &specs.Root{Path: t.TempDir()},
+	})
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(bundle, oci.ConfigFilename), config, 0o644))
+
+	_, err = s.Create(context.Background(), &taskAPI.CreateTaskRequest{
+		ID:     "test",
+		Bundle: bundle,
+	})
+	require.ErrorIs(t, errgrpc.ToNative(err), errdefs.ErrAlreadyExists)
+	require.Same(t, existing, s.containers["test"])
+}
+
+func TestExecAlreadyExists(t *testing.T) {
+	s := newTestService(t, task.Status_RUNNING)
+
+	_, err := s.Exec(context.Background(), execRequest(t, "exec"))
+	require.NoError(t, err)
+
+	existing := s.containers["test"].auxiliary["exec"]
+
+	_, err = s.Exec(context.Background(), execRequest(t, "exec"))
+	require.ErrorIs(t, errgrpc.ToNative(err), errdefs.ErrAlreadyExists)
+	require.Same(t, existing, s.containers["test"].auxiliary["exec"])
+
+	_, err = s.Exec(context.Background(), execRequest(t, ""))
+	require.ErrorIs(t, errgrpc.ToNative(err), errdefs.ErrInvalidArgument)
+}
+
+func TestInvalidTransitions(t *testing.T) {
+	for _, test := range []struct {
+		name   string
+		status task.Status
+		call   func(s *service) error
+	}{
+		{"exec in created container", task.Status_CREATED, func(s *service) error {
+			_, err := s.Exec(context.Background(), execRequest(t, "exec"))
+			return err
+		}},
+		{"exec in stopped container", task.Status_STOPPED, func(s *service) error {
+			_, err := s.Exec(context.Background(), execRequest(t, "exec"))
+			return err
+		}},
+		{"start running container", task.Status_RUNNING, func(s *service) error {
+			_, err := s.Start(context.Background(), &taskAPI.StartRequest{ID: "test"})
+			return err
+		}},
+		{"start stopped container", task.Status_STOPPED, func(s *service) error {
+			_, err := s.Start(context.Background(), &taskAPI.StartRequest{ID: "test"})
+			return err
+		}},
+		{"delete running container", task.Status_RUNNING, func(s *service) error {
+			_, err := s.Delete(context.Background(), &taskAPI.DeleteRequest{ID: "test"})
+			return err
+		}},
+		{"delete running exec", task.Status_RUNNING, func(s *service) error {
+			s.containers["test"].auxiliary["exec"] = runningProcess()
+			_, err := s.Delete(context.Background(), &taskAPI.DeleteRequest{ID: "test", ExecID: "exec"})
+			return err
+		}},
+		{"start running exec", task.Status_RUNNING, func(s *service) error {
+			s.containers["test"].auxiliary["exec"] = runningProcess()
+			_, err := s.Start(context.Background(), &taskAPI.StartRequest{ID: "test", ExecID: "exec"})
+			return err
+		}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			s := newTestService(t, test.status)
+			err := test.call(s)
+			require.ErrorIs(t, errgrpc.ToNative(err), errdefs.ErrFailedPrecondition)
+			require.Contains(t, s.containers, "test")
+		})
+	}
+}
+
+// TestServiceConcurrency runs lifecycle calls for a few containers concurrently, so the race detector can catch unsynchronized access.
+// Calls are expected to fail often, as they race with each other.
+func TestServiceConcurrency(t *testing.T) {
+	s := newTaskService(t, discardPublisher{})
+	ctx := context.Background()
+
+	const (
+		workers    = 8
+		iterations = 10
+	)
+
+	ids := []string{"a", "b", "c"}
+
+	bundles := make([][]string, workers)
+	for w := range bundles {
+		for i := 0; i < iterations; i++ {
+			bundles[w] = append(bundles[w], testutil.NewBundle(t, &specs.Spec{Process: testProcess("sleep", "10")}))
+		}
+	}
+
+	spec, err := typeurl.MarshalAnyToProto(testProcess("sleep", "10"))
+	require.NoError(t, err)
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for i := 0; i < iterations; i++ {
+				id := ids[rand.Intn(len(ids))]
+				execID := fmt.Sprintf("exec-%d-%d", w, i)
+
+				_, _ = s.Create(ctx, &taskAPI.CreateTaskRequest{ID: id, Bundle: bundles[w][i]})
+				_, _ = s.Start(ctx, &taskAPI.StartRequest{ID: id})
+				_, _ = s.State(ctx, &taskAPI.StateRequest{ID: id})
+				_, _ = s.Exec(ctx, &taskAPI.ExecProcessRequest{ID: id, ExecID: execID, Spec: spec})
+				_, _ = s.Start(ctx, &taskAPI.StartRequest{ID: id, ExecID: execID})
+				_, _ = s.State(ctx, &taskAPI.StateRequest{ID: id, ExecID: execID})
+				_, _ = s.Kill(ctx, &taskAPI.KillRequest{ID: id, ExecID: execID, Signal: uint32(syscall.SIGKILL)})
+				_, _ = s.Delete(ctx, &taskAPI.DeleteRequest{ID: id, ExecID: execID})
+				_, _ = s.Kill(ctx, &taskAPI.KillRequest{ID: id, Signal: uint32(syscall.SIGKILL)})
+				_, _ = s.Delete(ctx, &taskAPI.DeleteRequest{ID: id})
+			}
+		}()
+	}
+	wg.Wait()
+
+	for _, id := range ids {
+		_, _ = s.Kill(ctx, &taskAPI.KillRequest{ID: id, Signal: uint32(syscall.SIGKILL)})
+		require.Eventually(t, func() bool {
+			_, err := s.Delete(ctx, &taskAPI.DeleteRequest{ID: id})
+			_, stateErr := s.State(ctx, &taskAPI.StateRequest{ID: id})
+			return err == nil || stateErr != nil
+		}, 10*time.Second, 10*time.Millisecond)
+	}
+
+	require.Empty(t, s.containers)
+}
+
+func TestLifecycle(t *testing.T) {
+	h := newTestHarness(t)
+
+	h.create("test", &specs.Spec{Process: testProcess("sh", "-c", "echo primary; exec sleep 60")})
+
+	state := h.state("test", "")
+	require.Equal(t, task.Status_CREATED, state.Status)
+	require.Zero(t, state.Pid)
+
+	pid := h.start("test", "")
+	require.NotZero(t, pid)
+
+	state = h.state("test", "")
+	require.Equal(t, task.Status_RUNNING, state.Status)
+	require.Equal(t, pid, state.Pid)
+
+	h.exec("test", "exec", testProcess("sh", "-c", "echo exec; exit 5"))
+	require.Equal(t, task.Status_CREATED, h.state("test", "exec").Status)
+
+	output, status := h.run("test", "exec")
+	require.Equal(t, "exec\n", output)
+	require.Equal(t, uint32(5), status)
+	require.Equal(t, task.Status_STOPPED, h.state("test", "exec").Status)
+
+	h.delete("test", "exec")
+
+	_, err := h.service.State(h.ctx, &taskAPI.StateRequest{ID: "test", ExecID: "exec"})
+	require.ErrorIs(t, errgrpc.ToNative(err), errdefs.ErrNotFound)
+
+	h.kill("test", "", syscall.SIGTERM)
+	require.Equal(t, uint32(128+syscall.SIGTERM), h.wait("test", "").ExitStatus)
+	require.Equal(t, "primary\n", h.output("test", ""))
+
+	state = h.state("test", "")
+	require.Equal(t, task.Status_STOPPED, state.Status)
+	require.Equal(t, uint32(128+syscall.SIGTERM), state.ExitStatus)
+
+	h.delete("test", "")
+
+	_, err = h.service.State(h.ctx, &taskAPI.StateRequest{ID: "test"})
+	require.ErrorIs(t, errgrpc.ToNative(err), errdefs.ErrNotFound)
+
+	h.requireEvents(
+		"/tasks/create test",
+		"/tasks/start test",
+		"/tasks/exec-added test exec",
+		"/tasks/exec-started test exec",
+		"/tasks/exit test exec 5",
+		"/tasks/exit test test 143",
+		"/tasks/delete test 143",
+	)
+}
+
+func TestEventsRun(t *testing.T) {
+	h := newTestHarness(t)
+
+	h.create("test", &specs.Spec{Process: testProcess("sh", "-c", "exit 3")})
+	h.start("test", "")
+
+	wait := h.wait("test", "")
+	require.Equal(t, uint32(3), wait.ExitStatus)
+
+	state := h.state("test", "")
+	require.Equal(t, wait.ExitStatus, state.ExitStatus)
+	require.Equal(t, wait.ExitedAt.AsTime(), state.ExitedAt.AsTime())
+
+	deleted := h.delete("test", "")
+	require.Equal(t, wait.ExitStatus, deleted.ExitStatus)
+	require.Equal(t, wait.ExitedAt.AsTime(), deleted.ExitedAt.AsTime())
+
+	h.requireEvents(
+		"/tasks/create test",
+		"/tasks/start test",
+		"/tasks/exit test test 3",
+		"/tasks/delete test 3",
+	)
+}
+
+func TestEventsExec(t *testing.T) {
+	h := newTestHarness(t)
+
+	h.create("test", &specs.Spec{Process: testProcess("sleep", "60")})
+	h.start("test", "")
+
+	h.exec("test", "exec", testProcess("sh", "-c", "exit 2"))
+	h.start("test", "exec")
+	require.Equal(t, uint32(2), h.wait("test", "exec").ExitStatus)
+	h.delete("test", "exec")
+
+	h.kill("test", "", syscall.SIGKILL)
+	h.wait("test", "")
+	h.delete("test", "")
+
+	h.requireEvents(
+		"/tasks/create test",
+		"/tasks/start test",
+		"/tasks/exec-added test exec",
+		"/tasks/exec-started test exec",
+		"/tasks/exit test exec 2",
+		"/tasks/exit test test 137",
+		"/tasks/delete test 137",
+	)
+}
+
+func TestEventsKill(t *testing.T) {
+	h := newTestHarness(t)
+
+	h.create("test", &specs.Spec{Process: testProcess("sleep", "60")})
+	h.start("test", "")
+	h.kill("test", "", syscall.SIGKILL)
+
+	// Delete races with exit, it must not be published before the exit
+	require.Eventually(t, func() bool {
+		_, err := h.service.Delete(h.ctx, &taskAPI.DeleteRequest{ID: "test"})
+		return err == nil
+	}, 5*time.Second, time.Millisecond)
+
+	h.requireEvents(
+		"/tasks/create test",
+		"/tasks/start test",
+		"/tasks/exit test test 137",
+		"/tasks/delete test 137",
+	)
+}
+
+func TestEventsDeleteCreated(t *testing.T) {
+	h := newTestHarness(t)
+
+	h.create("test", &specs.Spec{Process: testProcess("true")})
+	p := h.service.containers["test"].primary
+
+	h.delete("test", "")
+
+	// Waiters of a process that has never been started are released on delete
+	select {
+	case <-p.waitblock:
+	case <-time.After(5 * time.Second):
+		t.Fatal("waiters are not released")
+	}
+	require.Equal(t, uint32(137), p.state.get().exitStatus)
+
+	h.requireEvents(
+		"/tasks/create test",
+		"/tasks/delete test 137",
+	)
+}
+
+func TestOutputOnExit(t *testing.T) {
+	for _, terminal := range []bool{false, true} {
+		t.Run(fmt.Sprintf("terminal=%v", terminal), func(t *testing.T) {
+			h := newTestHarness(t)
+
+			for i := range 10 {
+				id := fmt.Sprintf("test-%d", i)
+
+				process := testProcess("seq", "20000")
+				process.Terminal = terminal
+				h.create(id, &specs.Spec{Process: process})
+
+				output, status := h.run(id, "")
+				require.Zero(t, status)
+				require.Contains(t, output, "\n20000")
+
+				h.delete(id, "")
+			}
+		})
+	}
+}
+
+func TestKillPaused(t *testing.T) {
+	h := newTestHarness(t)
+
+	h.create("test", &specs.Spec{Process: testProcess("sleep", "60")})
+	h.start("test", "")
+	h.exec("test", "exec", testProcess("sleep", "60"))
+	h.start("test", "exec")
+
+	_, err := h.service.Pause(h.ctx, &taskAPI.PauseRequest{ID: "test"})
+	require.NoError(t, err)
+	require.Equal(t, task.Status_PAUSED, h.state("test", "").Status)
+	require.Equal(t, task.Status_PAUSED, h.state("test", "exec").Status)
+
+	// SIGTERM stays pending until the stopped process is continued, so the whole container is resumed
+	h.kill("test", "", syscall.SIGTERM)
+
+	p := h.service.containers["test"].primary
+	select {
+	case <-p.waitblock:
+	case <-time.After(5 * time.Second):
+		t.Fatal("paused process is not terminated")
+	}
+	require.Equal(t, uint32(128+syscall.SIGTERM), h.wait("test", "").ExitStatus)
+	require.Equal(t, task.Status_RUNNING, h.state("test", "exec").Status)
+
+	h.kill("test", "exec", syscall.SIGKILL)
+	require.Equal(t, uint32(128+syscall.SIGKILL), h.wait("test", "exec").ExitStatus)
+
+	// Exited process is not signaled anymore
+	_, err = h.service.Kill(h.ctx, &taskAPI.KillRequest{ID: "test", ExecID: "exec", Signal: uint32(syscall.SIGKILL)})
+	require.True(t, errdefs.IsNotFound(errgrpc.ToNative(err)), err)
+
+	h.delete("test", "exec")
+	h.delete("test", "")
+
+	h.requireEvents(
+		"/tasks/create test",
+		"/tasks/start test",
+		"/tasks/exec-added test exec",
+		"/tasks/exec-started test exec",
+		"/tasks/paused",
+		"/tasks/resumed",
+		"/tasks/exit test test 143",
+		"/tasks/exit test exec 137",
+		"/tasks/delete test 143",
+	)
+}
+
+func TestShutdownFlushUnlocked(t *testing.T) {
+	publisher := &blockingPublisher{unblock: make(chan struct{})}
+	s := newTaskService(t, publisher)
+	ctx := context.Background()
+
+	s.events.send(&events.TaskPaused{ContainerID: "test"})
+
+	shutdownDone := make(chan struct{})
+	go func() {
+		defer close(shutdownDone)
+
+		_, err := s.Shutdown(ctx, &taskAPI.ShutdownRequest{})
+		assert.NoError(t, err)
+	}()
+
+	// Other RPCs are served while Shutdown waits for the stalled publisher, no containers are created anymore
+	require.Eventually(t, func() bool {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		return s.shuttingDown
+	}, 5*time.Second, 10*time.Millisecond)
+
+	_, err := s.State(ctx, &taskAPI.StateRequest{ID: "test"})
+	require.True(t, errdefs.IsNotFound(errgrpc.ToNative(err)), err)
+
+	_, err = s.Create(ctx, &taskAPI.CreateTaskRequest{
+		ID:     "test",
+		Bundle: testutil.NewBundle(t, &specs.Spec{Process: testProcess("true")}),
+	})
+	require.True(t, errdefs.IsFailedPrecondition(errgrpc.ToNative(err)), err)
+
+	close(publisher.unblock)
+	select {
+	case <-shutdownDone:
+	case <-time.After(5 * time.Second):
+		t.Fatal("shutdown is not done")
+	}
+}
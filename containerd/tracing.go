@@ -0,0 +1,188 @@
+package containerd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/containerd/containerd/v2/pkg/shutdown"
+	"github.com/containerd/containerd/v2/plugins"
+	"github.com/containerd/errdefs"
+	"github.com/containerd/otelttrpc"
+	"github.com/containerd/plugin"
+	"github.com/containerd/plugin/registry"
+	"github.com/containerd/ttrpc"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Tracing is configured with standard OpenTelemetry environment variables that the shim inherits from containerd,
+// and tracesFileEnv that exports spans as JSON to a file.
+const (
+	tracesFileEnv = "RUND_TRACES_FILE"
+
+	sdkDisabledEnv        = "OTEL_SDK_DISABLED"
+	otlpEndpointEnv       = "OTEL_EXPORTER_OTLP_ENDPOINT"
+	otlpTracesEndpointEnv = "OTEL_EXPORTER_OTLP_TRACES_ENDPOINT"
+	otlpProtocolEnv       = "OTEL_EXPORTER_OTLP_PROTOCOL"
+	otlpTracesProtocolEnv = "OTEL_EXPORTER_OTLP_TRACES_PROTOCOL"
+	otelServiceNameEnv    = "OTEL_SERVICE_NAME"
+
+	tracerName = "github.com/darwin-containers/rund"
+	spanPrefix = "rund."
+)
+
+func init() {
+	// The shim looks up the plugin by id to propagate trace context of incoming requests and published events
+	registry.Register(&plugin.Registration{
+		Type: plugins.TTRPCPlugin,
+		ID:   "otelttrpc",
+		InitFn: func(ic *plugin.InitContext) (interface{}, error) {
+			return otelttrpcOpts{}, nil
+		},
+	})
+}
+
+type otelttrpcOpts struct{}
+
+func (otelttrpcOpts) UnaryServerInterceptor() ttrpc.UnaryServerInterceptor {
+	return otelttrpc.UnaryServerInterceptor()
+}
+
+func (otelttrpcOpts) UnaryClientInterceptor() ttrpc.UnaryClientInterceptor {
+	return otelttrpc.UnaryClientInterceptor()
+}
+
+// setupTracing installs the global tracer provider if an exporter is configured.
+// Pending spans are flushed on shutdown.
+func setupTracing(ctx context.Context, sd shutdown.Service) error {
+	if v := os.Getenv(sdkDisabledEnv); v != "" {
+		disabled, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("invalid value for %s: %w: %w", sdkDisabledEnv, err, errdefs.ErrInvalidArgument)
+		}
+		if disabled {
+			return nil
+		}
+	}
+
+	var (
+		opts []sdktrace.TracerProviderOption
+		file *os.File
+	)
+
+	if os.Getenv(otlpEndpointEnv) != "" || os.Getenv(otlpTracesEndpointEnv) != "" {
+		exporter, err := newOTLPExporter(ctx)
+		if err != nil {
+			return err
+		}
+		opts = append(opts, sdktrace.WithBatcher(exporter))
+	}
+
+	if path := os.Getenv(tracesFileEnv); path != "" {
+		var err error
+		if file, err = os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644); err != nil {
+			return err
+		}
+
+		exporter, err := stdouttrace.New(stdouttrace.WithWriter(file))
+		if err != nil {
+			_ = file.Close()
+			return err
+		}
+		opts = append(opts, sdktrace.WithBatcher(exporter))
+	}
+
+	if len(opts) == 0 {
+		return nil
+	}
+
+	// Let otel configure the service name from env
+	if os.Getenv(otelServiceNameEnv) == "" {
+		_ = os.Setenv(otelServiceNameEnv, "rund")
+	}
+
+	provider := sdktrace.NewTracerProvider(opts...)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	sd.RegisterCallback(func(ctx context.Context) error {
+		err := provider.Shutdown(ctx)
+		if file != nil {
+			_ = file.Close()
+		}
+		return err
+	})
+
+	return nil
+}
+
+// newOTLPExporter creates an exporter for the protocol configured by env, http/protobuf is the default.
+func newOTLPExporter(ctx context.Context) (sdktrace.SpanExporter, error) {
+	const timeout = 5 * time.Second
+
+	protocol := os.Getenv(otlpTracesProtocolEnv)
+	if protocol == "" {
+		protocol = os.Getenv(otlpProtocolEnv)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	switch protocol {
+	case "", "http/protobuf":
+		return otlptracehttp.New(ctx)
+	case "grpc":
+		return otlptracegrpc.New(ctx)
+	default:
+		return nil, fmt.Errorf("OpenTelemetry protocol %q: %w", protocol, errdefs.ErrNotImplemented)
+	}
+}
+
+// startSpan starts span of rund operation name.
+func startSpan(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return otel.Tracer(tracerName).Start(ctx, spanPrefix+name, trace.WithAttributes(attrs...))
+}
+
+// endSpan records the result of the operation and ends span.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// traced runs fn in span of rund operation name.
+func traced(ctx context.Context, name string, fn func(context.Context) error) error {
+	ctx, span := startSpan(ctx, name)
+	err := fn(ctx)
+	endSpan(span, err)
+
+	return err
+}
+
+// startRPC starts span of RPC method and logs its request, done ends the span and logs the result.
+func startRPC(ctx context.Context, method, id, execID string, request interface{}) (_ context.Context, done func(error)) {
+	attrs := []attribute.KeyValue{attribute.String("container.id", id)}
+	if execID != "" {
+		attrs = append(attrs, attribute.String("exec.id", execID))
+	}
+
+	ctx, span := startSpan(ctx, method, attrs...)
+	ctx, logDone := logRPC(ctx, method, id, execID, request)
+
+	return ctx, func(err error) {
+		logDone(err)
+		endSpan(span, err)
+	}
+}
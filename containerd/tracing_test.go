@@ -0,0 +1,111 @@
+package containerd
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	taskAPI "github.com/containerd/containerd/api/runtime/task/v3"
+	"github.com/containerd/containerd/v2/pkg/shutdown"
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// recordSpans installs global tracer provider that records spans in memory.
+func recordSpans(t *testing.T) *tracetest.InMemoryExporter {
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	otel.SetTracerProvider(provider)
+	t.Cleanup(func() {
+		_ = provider.Shutdown(context.Background())
+	})
+
+	return exporter
+}
+
+func spanNames(spans tracetest.SpanStubs) []string {
+	var names []string
+	for _, span := range spans {
+		names = append(names, span.Name)
+	}
+	return names
+}
+
+func TestStartRPC(t *testing.T) {
+	exporter := recordSpans(t)
+
+	failure := errors.New("failure")
+
+	ctx, done := startRPC(context.Background(), "Kill", "test", "exec", &taskAPI.KillRequest{ID: "test", ExecID: "exec"})
+	err := traced(ctx, "inner", func(context.Context) error {
+		return failure
+	})
+	done(err)
+
+	spans := exporter.GetSpans()
+	require.Equal(t, []string{"rund.inner", "rund.Kill"}, spanNames(spans))
+
+	inner, rpc := spans[0], spans[1]
+	require.Equal(t, rpc.SpanContext.SpanID(), inner.Parent.SpanID())
+	require.Equal(t, codes.Error, rpc.Status.Code)
+	require.Equal(t, "failure", rpc.Status.Description)
+	require.ElementsMatch(t, []attribute.KeyValue{
+		attribute.String("container.id", "test"),
+		attribute.String("exec.id", "exec"),
+	}, rpc.Attributes)
+}
+
+func TestTracesFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "traces.json")
+	t.Setenv(tracesFileEnv, path)
+	t.Setenv(otelServiceNameEnv, "rund-test")
+
+	ctx, sd := shutdown.WithShutdown(context.Background())
+	require.NoError(t, setupTracing(ctx, sd))
+
+	_, done := startRPC(ctx, "State", "test", "", &taskAPI.StateRequest{ID: "test"})
+	done(nil)
+
+	sd.Shutdown()
+	<-sd.Done()
+
+	content, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.Contains(t, string(content), `"Name":"rund.State"`)
+}
+
+func TestTraceLifecycle(t *testing.T) {
+	h := newTestHarness(t)
+	exporter := recordSpans(t)
+
+	h.create("test", &specs.Spec{Process: testProcess("true")})
+	_, status := h.run("test", "")
+	require.Zero(t, status)
+	h.delete("test", "")
+
+	require.Eventually(t, func() bool {
+		return len(exporter.GetSpans()) >= 10
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// Rootfs and mounts of the spec are mounted separately
+	require.ElementsMatch(t, []string{
+		"rund.processMounts",
+		"rund.mount.All",
+		"rund.processMounts",
+		"rund.mount.All",
+		"rund.Create",
+		"rund.Start",
+		"rund.wait",
+		"rund.Wait",
+		"rund.container.destroy",
+		"rund.Delete",
+	}, spanNames(exporter.GetSpans()))
+}
@@ -0,0 +1,222 @@
+package containerd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/containerd/errdefs"
+	"github.com/containerd/errdefs/pkg/errgrpc"
+	"github.com/moby/sys/user"
+	"github.com/opencontainers/runtime-spec/specs-go"
+)
+
+const (
+	passwdPath = "/etc/passwd"
+	groupPath  = "/etc/group"
+
+	// directoryServicesPath is the local node of Darwin directory services,
+	// it has a property list file per user and group
+	directoryServicesPath = "/var/db/dslocal/nodes/Default"
+)
+
+// execUser is the user a container process runs as
+type execUser struct {
+	uid    uint32
+	gid    uint32
+	groups []uint32
+	home   string
+}
+
+// resolveUser resolves user of a process against passwd and group files of the container rootfs.
+// If the user is specified by name and is missing from passwd file,
+// Darwin directory services records of the rootfs are looked up as well.
+func resolveUser(rootfs string, u specs.User) (*execUser, error) {
+	defaults := &user.ExecUser{
+		Uid:  int(u.UID),
+		Gid:  int(u.GID),
+		Home: "/",
+	}
+
+	userSpec := u.Username
+	if userSpec == "" {
+		userSpec = fmt.Sprintf("%d:%d", u.UID, u.GID)
+	}
+
+	passwd, err := rootfsPath(rootfs, passwdPath)
+	if err != nil {
+		return nil, err
+	}
+
+	group, err := rootfsPath(rootfs, groupPath)
+	if err != nil {
+		return nil, err
+	}
+
+	eu, err := user.GetExecUserPath(userSpec, defaults, passwd, group)
+	if err != nil && u.Username != "" {
+		dsUser, dsErr := lookupDirectoryServicesUser(rootfs, userSpec, defaults)
+		if dsErr == nil {
+			eu, err = dsUser, nil
+		} else if !errors.Is(dsErr, os.ErrNotExist) {
+			err = dsErr
+		}
+	}
+	if err != nil {
+		return nil, errgrpc.ToGRPCf(errdefs.ErrInvalidArgument, "unable to find user %q in container: %v", userSpec, unwrapPathError(err))
+	}
+
+	result := &execUser{
+		uid:    uint32(eu.Uid),
+		gid:    uint32(eu.Gid),
+		groups: slices.Clone(u.AdditionalGids),
+		home:   eu.Home,
+	}
+
+	for _, gid := range eu.Sgids {
+		result.groups = append(result.groups, uint32(gid))
+	}
+
+	return result, nil
+}
+
+// lookupDirectoryServicesUser resolves "user[:group]" spec against Darwin directory services records.
+func lookupDirectoryServicesUser(rootfs, userSpec string, defaults *user.ExecUser) (*user.ExecUser, error) {
+	name, groupName, hasGroup := strings.Cut(userSpec, ":")
+
+	record, err := readDirectoryServicesRecord(rootfs, "users", name)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &user.ExecUser{
+		Uid:  defaults.Uid,
+		Gid:  defaults.Gid,
+		Home: defaults.Home,
+	}
+
+	if result.Uid, err = recordInt(record, "uid", result.Uid); err != nil {
+		return nil, err
+	}
+
+	if result.Gid, err = recordInt(record, "gid", result.Gid); err != nil {
+		return nil, err
+	}
+
+	if home := record["home"]; len(home) > 0 && home[0] != "" {
+		result.Home = home[0]
+	}
+
+	if hasGroup {
+		if gid, err := strconv.Atoi(groupName); err == nil {
+			result.Gid = gid
+		} else {
+			groupRecord, err := readDirectoryServicesRecord(rootfs, "groups", groupName)
+			if err != nil {
+				return nil, err
+			}
+
+			if result.Gid, err = recordInt(groupRecord, "gid", result.Gid); err != nil {
+				return nil, err
+			}
+		}
+	} else {
+		// Same as for passwd, supplementary groups are only used when group is not specified explicitly
+		if result.Sgids, err = lookupDirectoryServicesGroups(rootfs, name); err != nil {
+			return nil, err
+		}
+	}
+
+	return result, nil
+}
+
+// lookupDirectoryServicesGroups returns ids of groups the user is a member of
+func lookupDirectoryServicesGroups(rootfs, name string) ([]int, error) {
+	groupsDir, err := rootfsPath(rootfs, filepath.Join(directoryServicesPath, "groups"))
+	if err != nil {
+		return nil, err
+	}
+
+	groups, err := filepath.Glob(filepath.Join(groupsDir, "*.plist"))
+	if err != nil {
+		return nil, err
+	}
+
+	var gids []int
+	for _, g := range groups {
+		record, err := readDirectoryServicesRecord(rootfs, "groups", strings.TrimSuffix(filepath.Base(g), ".plist"))
+		if err != nil {
+			return nil, err
+		}
+
+		if slices.Contains(record["users"], name) {
+			gid, err := recordInt(record, "gid", -1)
+			if err != nil {
+				return nil, err
+			}
+
+			if gid >= 0 {
+				gids = append(gids, gid)
+			}
+		}
+	}
+
+	return gids, nil
+}
+
+// readDirectoryServicesRecord reads a record of directory services, every attribute of a record is a list of strings
+func readDirectoryServicesRecord(rootfs, kind, name string) (map[string][]string, error) {
+	if name == "" || strings.ContainsAny(name, "/") || name == "." || name == ".." {
+		return nil, fmt.Errorf("invalid %s name: %q", kind, name)
+	}
+
+	p, err := rootfsPath(rootfs, filepath.Join(directoryServicesPath, kind, name+".plist"))
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(p)
+	if err != nil {
+		return nil, err
+	}
+
+	plist, err := parsePlist(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", p, err)
+	}
+
+	dict, ok := plist.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("%s: not a directory services record", p)
+	}
+
+	record := make(map[string][]string, len(dict))
+	for key, value := range dict {
+		values, _ := value.([]any)
+		for _, v := range values {
+			if s, ok := v.(string); ok {
+				record[key] = append(record[key], s)
+			}
+		}
+	}
+
+	return record, nil
+}
+
+func recordInt(record map[string][]string, key string, defaultValue int) (int, error) {
+	values := record[key]
+	if len(values) == 0 {
+		return defaultValue, nil
+	}
+
+	value, err := strconv.Atoi(values[0])
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s %q: %w", key, values[0], err)
+	}
+
+	return value, nil
+}
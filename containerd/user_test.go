@@ -0,0 +1,210 @@
+package containerd
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/containerd/errdefs"
+	"github.com/containerd/errdefs/pkg/errgrpc"
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/stretchr/testify/require"
+)
+
+func writeFile(t *testing.T, path string, data string) {
+	require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+	require.NoError(t, os.WriteFile(path, []byte(data), 0o644))
+}
+
+func testRootfs(t *testing.T) string {
+	rootfs := t.TempDir()
+
+	writeFile(t, filepath.Join(rootfs, "private", "etc", "passwd"), `root:*:0:0:System Administrator:/var/root:/bin/sh
+builder:*:501:20:Builder:/Users/builder:/bin/sh
+`)
+	writeFile(t, filepath.Join(rootfs, "private", "etc", "group"), `wheel:*:0:root
+staff:*:20:root
+admin:*:80:builder
+`)
+	// Same layout as on Darwin, /etc is an absolute symlink
+	require.NoError(t, os.Symlink("/private/etc", filepath.Join(rootfs, "etc")))
+
+	return rootfs
+}
+
+func TestRootfsPath(t *testing.T) {
+	rootfs := testRootfs(t)
+	require.NoError(t, os.Symlink("../../..", filepath.Join(rootfs, "private", "escape")))
+
+	for _, test := range []struct {
+		path     string
+		expected string
+	}{
+		{"/etc/passwd", "/private/etc/passwd"},
+		{"etc/../etc/group", "/private/etc/group"},
+		{"/private/escape/etc", "/private/etc"},
+		{"/../../missing/file", "/missing/file"},
+	} {
+		actual, err := rootfsPath(rootfs, test.path)
+		require.NoError(t, err, test.path)
+		require.Equal(t, filepath.Join(rootfs, test.expected), actual, test.path)
+	}
+
+	require.NoError(t, os.Symlink("loop", filepath.Join(rootfs, "loop")))
+	_, err := rootfsPath(rootfs, "/loop")
+	require.Error(t, err)
+}
+
+func TestResolveUser(t *testing.T) {
+	rootfs := testRootfs(t)
+
+	for _, test := range []struct {
+		name     string
+		user     specs.User
+		expected execUser
+	}{
+		{"root", specs.User{}, execUser{uid: 0, gid: 0, groups: []uint32{}, home: "/var/root"}},
+		{"username", specs.User{Username: "builder"}, execUser{uid: 501, gid: 20, groups: []uint32{80}, home: "/Users/builder"}},
+		{"username and group", specs.User{Username: "builder:admin"}, execUser{uid: 501, gid: 80, groups: []uint32{}, home: "/Users/builder"}},
+		{"uid", specs.User{UID: 501, GID: 80, AdditionalGids: []uint32{1}}, execUser{uid: 501, gid: 80, groups: []uint32{1}, home: "/Users/builder"}},
+		{"unknown uid", specs.User{UID: 1000, GID: 1000}, execUser{uid: 1000, gid: 1000, groups: []uint32{}, home: "/"}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			actual, err := resolveUser(rootfs, test.user)
+			require.NoError(t, err)
+			if len(actual.groups) == 0 {
+				actual.groups = []uint32{}
+			}
+			require.Equal(t, test.expected, *actual)
+		})
+	}
+}
+
+func TestResolveUserUnknown(t *testing.T) {
+	_, err := resolveUser(testRootfs(t), specs.User{Username: "nobody"})
+	require.True(t, errdefs.IsInvalidArgument(errgrpc.ToNative(err)), err)
+	require.ErrorContains(t, err, `unable to find user "nobody" in container`)
+
+	_, err = resolveUser(testRootfs(t), specs.User{Username: "builder:nogroup"})
+	require.True(t, errdefs.IsInvalidArgument(errgrpc.ToNative(err)), err)
+}
+
+func TestResolveUserDirectoryServices(t *testing.T) {
+	rootfs := testRootfs(t)
+	dslocal := filepath.Join(rootfs, directoryServicesPath)
+
+	writeFile(t, filepath.Join(dslocal, "users", "runner.plist"), `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+	<key>name</key>
+	<array>
+		<string>runner</string>
+	</array>
+	<key>uid</key>
+	<array>
+		<string>502</string>
+	</array>
+	<key>gid</key>
+	<array>
+		<string>20</string>
+	</array>
+	<key>home</key>
+	<array>
+		<string>/Users/runner</string>
+	</array>
+</dict>
+</plist>
+`)
+	writeFile(t, filepath.Join(dslocal, "groups", "builders.plist"), binaryPlistDict(map[string][]string{
+		"name":  {"builders"},
+		"gid":   {"701"},
+		"users": {"builder", "runner"},
+	}))
+
+	actual, err := resolveUser(rootfs, specs.User{Username: "runner"})
+	require.NoError(t, err)
+	require.Equal(t, execUser{uid: 502, gid: 20, groups: []uint32{701}, home: "/Users/runner"}, *actual)
+
+	actual, err = resolveUser(rootfs, specs.User{Username: "runner:builders"})
+	require.NoError(t, err)
+	require.Equal(t, uint32(701), actual.gid)
+}
+
+// binaryPlistDict encodes a dictionary of string arrays as a binary property list
+func binaryPlistDict(dict map[string][]string) string {
+	var objects [][]byte
+
+	add := func(object []byte) byte {
+		objects = append(objects, object)
+		return byte(len(objects) - 1)
+	}
+
+	str := func(s string) byte {
+		return add(append([]byte{0x50 | byte(len(s))}, s...))
+	}
+
+	// Top-level dictionary is filled in once all of its keys and values are known
+	top := add(nil)
+
+	var keys, values []byte
+	for k, v := range dict {
+		keys = append(keys, str(k))
+
+		refs := []byte{0xa0 | byte(len(v))}
+		for _, s := range v {
+			refs = append(refs, str(s))
+		}
+		values = append(values, add(refs))
+	}
+	objects[top] = append(append([]byte{0xd0 | byte(len(dict))}, keys...), values...)
+
+	return encodeBinaryPlist(objects, top)
+}
+
+// encodeBinaryPlist encodes objects with one byte refs and offsets as a binary property list
+func encodeBinaryPlist(objects [][]byte, top byte) string {
+	data := []byte("bplist00")
+	var offsets []byte
+	for _, object := range objects {
+		offsets = append(offsets, byte(len(data)))
+		data = append(data, object...)
+	}
+
+	offsetTableOffset := len(data)
+	data = append(data, offsets...)
+
+	trailer := make([]byte, 32)
+	trailer[6] = 1
+	trailer[7] = 1
+	binary.BigEndian.PutUint64(trailer[8:], uint64(len(objects)))
+	binary.BigEndian.PutUint64(trailer[16:], uint64(top))
+	binary.BigEndian.PutUint64(trailer[24:], uint64(offsetTableOffset))
+
+	return string(append(data, trailer...))
+}
+
+func TestBinaryPlistSharedObjects(t *testing.T) {
+	// Every array refers to the next one four times, the leaf is reached 4^30 times without caching
+	var objects [][]byte
+	for i := range 30 {
+		next := byte(i + 1)
+		objects = append(objects, []byte{0xa4, next, next, next, next})
+	}
+	objects = append(objects, []byte{0x54, 'l', 'e', 'a', 'f'})
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := parsePlist([]byte(encodeBinaryPlist(objects, 0)))
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		require.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("parsing shared objects takes too long")
+	}
+}
@@ -0,0 +1,241 @@
+package containerd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/containerd/containerd/api/types"
+	"github.com/containerd/errdefs"
+	"github.com/containerd/errdefs/pkg/errgrpc"
+	"github.com/containerd/log"
+	"github.com/opencontainers/runtime-spec/specs-go"
+)
+
+// validationMode tells what happens to specs with unsupported fields.
+type validationMode string
+
+const (
+	// validationPermissive logs a warning per unsupported field and ignores the field
+	validationPermissive validationMode = "permissive"
+	// validationStrict rejects specs with unsupported fields
+	validationStrict validationMode = "strict"
+)
+
+// defaultValidationMode is permissive, as specs generated by Docker and containerd always set some unsupported fields.
+// It is changed with ValidationMode option, and per container with validationAnnotation.
+const defaultValidationMode = validationPermissive
+
+// unsupportedField is a spec field that is set, but isn't supported by rund.
+type unsupportedField struct {
+	// field is the JSON path of the field in the spec
+	field  string
+	reason string
+}
+
+func (u unsupportedField) String() string {
+	return u.field + ": " + u.reason
+}
+
+// checkUnsupported handles unsupported fields according to mode.
+// In strict mode it returns an error that lists all of them, in permissive mode it logs a warning per field.
+func checkUnsupported(ctx context.Context, mode validationMode, unsupported []unsupportedField) error {
+	if len(unsupported) == 0 {
+		return nil
+	}
+
+	if mode == validationStrict {
+		fields := make([]string, 0, len(unsupported))
+		for _, u := range unsupported {
+			fields = append(fields, u.String())
+		}
+
+		return errgrpc.ToGRPCf(errdefs.ErrNotImplemented, "spec sets fields that rund doesn't support: %s; "+
+			"remove them or set %s=%s annotation to ignore them", strings.Join(fields, "; "), validationAnnotation, validationPermissive)
+	}
+
+	for _, u := range unsupported {
+		log.G(ctx).WithField("field", u.field).Warnf("ignoring unsupported spec field: %s", u.reason)
+	}
+
+	return nil
+}
+
+// validateSpec returns fields of container spec that rund doesn't support.
+// Supported values are the capabilities reported by RuntimeFeatures.
+func validateSpec(spec *specs.Spec) []unsupportedField {
+	var unsupported []unsupportedField
+	add := func(field, format string, args ...any) {
+		unsupported = append(unsupported, unsupportedField{field: field, reason: fmt.Sprintf(format, args...)})
+	}
+
+	if spec.Root != nil && spec.Root.Readonly {
+		add("root.readonly", "rootfs is always writable")
+	}
+
+	if spec.Hostname != "" {
+		add("hostname", "containers share hostname of the host")
+	}
+	if spec.Domainname != "" {
+		add("domainname", "containers share domain name of the host")
+	}
+
+	for i, m := range spec.Mounts {
+		unsupported = append(unsupported, validateMount(fmt.Sprintf("mounts[%d]", i), m.Type, m.Source, m.Options)...)
+	}
+
+	if spec.Hooks != nil {
+		for name, list := range map[string][]specs.Hook{
+			"prestart":        spec.Hooks.Prestart, //nolint:staticcheck // Deprecated hooks are reported as well
+			"createRuntime":   spec.Hooks.CreateRuntime,
+			"createContainer": spec.Hooks.CreateContainer,
+			"startContainer":  spec.Hooks.StartContainer,
+			"poststart":       spec.Hooks.Poststart,
+			"poststop":        spec.Hooks.Poststop,
+		} {
+			if len(list) > 0 && !slices.Contains(hooks, name) {
+				add("hooks."+name, "hooks are not run")
+			}
+		}
+	}
+
+	if spec.Process != nil {
+		unsupported = append(unsupported, validateProcess("process", spec.Process)...)
+	}
+
+	if spec.Linux != nil {
+		unsupported = append(unsupported, validateLinux(spec.Linux)...)
+	}
+
+	for field, set := range map[string]bool{
+		"solaris": spec.Solaris != nil,
+		"windows": spec.Windows != nil,
+		"vm":      spec.VM != nil,
+		"zos":     spec.ZOS != nil,
+	} {
+		if set {
+			add(field, "platform is not supported")
+		}
+	}
+
+	slices.SortStableFunc(unsupported, func(a, b unsupportedField) int {
+		return strings.Compare(a.field, b.field)
+	})
+
+	return unsupported
+}
+
+// validateRootfs returns fields of rootfs mounts of a create request that rund doesn't support.
+func validateRootfs(rootfs []*types.Mount) []unsupportedField {
+	var unsupported []unsupportedField
+	for i, m := range rootfs {
+		unsupported = append(unsupported, validateMount(fmt.Sprintf("rootfs[%d]", i), m.Type, m.Source, m.Options)...)
+	}
+
+	return unsupported
+}
+
+// validateMount returns fields of mount at path field that rund doesn't support, processMount skips such mounts.
+func validateMount(field, mtype, source string, options []string) []unsupportedField {
+	var unsupported []unsupportedField
+	add := func(name, format string, args ...any) {
+		unsupported = append(unsupported, unsupportedField{field: field + "." + name, reason: fmt.Sprintf(format, args...)})
+	}
+
+	if !slices.Contains(mountTypes, mtype) {
+		add("type", "mount type %q is not supported, supported types are %s", mtype, strings.Join(mountTypes, ", "))
+		return unsupported
+	}
+
+	if mtype != mountTypeBind {
+		return unsupported
+	}
+
+	for _, o := range options {
+		if !slices.Contains(mountOptions, o) {
+			add("options", "mount option %q is not supported, supported options are %s", o, strings.Join(mountOptions, ", "))
+		}
+	}
+
+	// Missing sources fail the mount itself
+	if stat, err := os.Stat(source); err == nil && !stat.IsDir() {
+		add("source", "bind mounts of files are not supported, only directories are")
+	}
+
+	return unsupported
+}
+
+// validateProcess returns fields of process spec at path field that rund doesn't support.
+func validateProcess(field string, process *specs.Process) []unsupportedField {
+	var unsupported []unsupportedField
+	add := func(name, reason string) {
+		unsupported = append(unsupported, unsupportedField{field: field + "." + name, reason: reason})
+	}
+
+	for i, rlimit := range process.Rlimits {
+		if !slices.Contains(rlimits, rlimit.Type) {
+			add(fmt.Sprintf("rlimits[%d]", i), fmt.Sprintf("rlimit %s is not applied", rlimit.Type))
+		}
+	}
+
+	if process.Capabilities != nil {
+		add("capabilities", "processes run with all privileges of their user")
+	}
+	if process.NoNewPrivileges {
+		add("noNewPrivileges", "processes can gain privileges with setuid binaries")
+	}
+	if process.ApparmorProfile != "" {
+		add("apparmorProfile", "AppArmor is not available on Darwin")
+	}
+	if process.SelinuxLabel != "" {
+		add("selinuxLabel", "SELinux is not available on Darwin")
+	}
+	if process.OOMScoreAdj != nil {
+		add("oomScoreAdj", "OOM score is not adjusted")
+	}
+	if process.Scheduler != nil {
+		add("scheduler", "scheduling policy is not applied")
+	}
+	if process.IOPriority != nil {
+		add("ioPriority", "I/O priority is not applied")
+	}
+	if process.ExecCPUAffinity != nil {
+		add("execCPUAffinity", "CPU affinity is not applied")
+	}
+	if process.User.Umask != nil {
+		add("user.umask", "umask is not applied")
+	}
+
+	return unsupported
+}
+
+// validateLinux returns fields of Linux specific configuration that rund doesn't support.
+// rund isolates containers only with chroot, so none of them is applied.
+func validateLinux(linux *specs.Linux) []unsupportedField {
+	var unsupported []unsupportedField
+	for field, set := range map[string]bool{
+		"namespaces":        len(linux.Namespaces) > 0,
+		"uidMappings":       len(linux.UIDMappings) > 0,
+		"gidMappings":       len(linux.GIDMappings) > 0,
+		"timeOffsets":       len(linux.TimeOffsets) > 0,
+		"devices":           len(linux.Devices) > 0,
+		"cgroupsPath":       linux.CgroupsPath != "",
+		"resources":         linux.Resources != nil,
+		"rootfsPropagation": linux.RootfsPropagation != "",
+		"seccomp":           linux.Seccomp != nil,
+		"sysctl":            len(linux.Sysctl) > 0,
+		"maskedPaths":       len(linux.MaskedPaths) > 0,
+		"readonlyPaths":     len(linux.ReadonlyPaths) > 0,
+		"mountLabel":        linux.MountLabel != "",
+		"intelRdt":          linux.IntelRdt != nil,
+		"personality":       linux.Personality != nil,
+	} {
+		if set {
+			unsupported = append(unsupported, unsupportedField{field: "linux." + field, reason: "Linux isolation is not available on Darwin"})
+		}
+	}
+
+	return unsupported
+}
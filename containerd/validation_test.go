@@ -0,0 +1,177 @@
+package containerd
+
+import (
+	"context"
+	"syscall"
+	"testing"
+
+	taskAPI "github.com/containerd/containerd/api/runtime/task/v3"
+	"github.com/containerd/containerd/api/types"
+	"github.com/containerd/errdefs"
+	"github.com/containerd/errdefs/pkg/errgrpc"
+	"github.com/containerd/typeurl/v2"
+	"github.com/darwin-containers/rund/internal/testutil"
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateSpec(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		spec     specs.Spec
+		expected []string
+	}{
+		{
+			name: "supported",
+			spec: specs.Spec{
+				Root:    &specs.Root{Path: "rootfs"},
+				Process: &specs.Process{Args: []string{"sh"}, Terminal: true, User: specs.User{UID: 1, AdditionalGids: []uint32{2}}},
+				Mounts: []specs.Mount{
+					{Type: "bind", Source: "/src", Destination: "/dst", Options: []string{"rbind", "ro"}},
+					{Type: "devfs", Source: "devfs", Destination: "/dev"},
+				},
+				Hooks:       &specs.Hooks{},
+				Annotations: map[string]string{logFileAnnotation: "true", validationAnnotation: "strict", "org.example": "value"},
+			},
+		},
+		{
+			name:     "readonly rootfs",
+			spec:     specs.Spec{Root: &specs.Root{Path: "rootfs", Readonly: true}},
+			expected: []string{"root.readonly"},
+		},
+		{
+			name:     "hostname",
+			spec:     specs.Spec{Hostname: "container", Domainname: "example.com"},
+			expected: []string{"domainname", "hostname"},
+		},
+		{
+			name: "mounts",
+			spec: specs.Spec{Mounts: []specs.Mount{
+				{Type: "tmpfs", Source: "tmpfs", Destination: "/tmp"},
+				{Type: "bind", Source: "/src", Destination: "/dst", Options: []string{"nosuid", "rbind", "nodev"}},
+				{Type: "bind", Source: "/bin/sh", Destination: "/sh"},
+			}},
+			expected: []string{"mounts[0].type", "mounts[1].options", "mounts[1].options", "mounts[2].source"},
+		},
+		{
+			name:     "hooks",
+			spec:     specs.Spec{Hooks: &specs.Hooks{CreateRuntime: []specs.Hook{{Path: "/bin/true"}}, Poststop: []specs.Hook{{Path: "/bin/true"}}}},
+			expected: []string{"hooks.createRuntime", "hooks.poststop"},
+		},
+		{
+			name: "process",
+			spec: specs.Spec{Process: &specs.Process{
+				Rlimits:         []specs.POSIXRlimit{{Type: "RLIMIT_NOFILE", Hard: 1024, Soft: 1024}},
+				Capabilities:    &specs.LinuxCapabilities{Bounding: []string{"CAP_CHOWN"}},
+				NoNewPrivileges: true,
+				ApparmorProfile: "docker-default",
+				User:            specs.User{Umask: new(uint32)},
+			}},
+			expected: []string{"process.apparmorProfile", "process.capabilities", "process.noNewPrivileges", "process.rlimits[0]", "process.user.umask"},
+		},
+		{
+			name: "linux",
+			spec: specs.Spec{Linux: &specs.Linux{
+				Namespaces:  []specs.LinuxNamespace{{Type: specs.PIDNamespace}},
+				Devices:     []specs.LinuxDevice{{Path: "/dev/fuse", Type: "c"}},
+				CgroupsPath: "/rund",
+				Resources:   &specs.LinuxResources{},
+				Seccomp:     &specs.LinuxSeccomp{DefaultAction: specs.ActAllow},
+			}},
+			expected: []string{"linux.cgroupsPath", "linux.devices", "linux.namespaces", "linux.resources", "linux.seccomp"},
+		},
+		{
+			name:     "empty linux section",
+			spec:     specs.Spec{Linux: &specs.Linux{}},
+			expected: nil,
+		},
+		{
+			name:     "other platforms",
+			spec:     specs.Spec{Windows: &specs.Windows{}, VM: &specs.VM{}},
+			expected: []string{"vm", "windows"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var fields []string
+			for _, u := range validateSpec(&tc.spec) {
+				require.NotEmpty(t, u.reason)
+				fields = append(fields, u.field)
+			}
+
+			require.Equal(t, tc.expected, fields)
+		})
+	}
+}
+
+func TestValidateRootfs(t *testing.T) {
+	unsupported := validateRootfs([]*types.Mount{
+		{Type: "overlay", Source: "overlay", Options: []string{"lowerdir=/lower"}},
+		{Type: "bind", Source: t.TempDir(), Options: []string{"rbind", "nodev"}},
+		{Type: "bind", Source: t.TempDir(), Options: []string{"rbind", "ro"}},
+	})
+
+	var fields []string
+	for _, u := range unsupported {
+		fields = append(fields, u.field)
+	}
+	require.Equal(t, []string{"rootfs[0].type", "rootfs[1].options"}, fields)
+}
+
+func TestCheckUnsupported(t *testing.T) {
+	ctx := context.Background()
+	unsupported := []unsupportedField{
+		{field: "hostname", reason: "containers share hostname of the host"},
+		{field: "linux.seccomp", reason: "Linux isolation is not available on Darwin"},
+	}
+
+	require.NoError(t, checkUnsupported(ctx, validationStrict, nil))
+	require.NoError(t, checkUnsupported(ctx, validationPermissive, unsupported))
+
+	err := errgrpc.ToNative(checkUnsupported(ctx, validationStrict, unsupported))
+	require.True(t, errdefs.IsNotImplemented(err), err)
+	require.ErrorContains(t, err, "hostname: containers share hostname of the host; linux.seccomp: Linux isolation is not available on Darwin")
+	require.ErrorContains(t, err, "io.rund.validation=permissive")
+}
+
+func TestStrictValidation(t *testing.T) {
+	h := newTestHarness(t)
+	strict := map[string]string{validationAnnotation: "strict"}
+
+	// Containers with unsupported fields are not created in strict mode
+	_, err := h.service.Create(h.ctx, &taskAPI.CreateTaskRequest{
+		ID:     "rejected",
+		Bundle: testutil.NewBundle(t, &specs.Spec{Process: testProcess("true"), Hostname: "rejected", Annotations: strict}),
+	})
+	require.True(t, errdefs.IsNotImplemented(errgrpc.ToNative(err)), err)
+	require.ErrorContains(t, err, "hostname")
+
+	// Rootfs mounts that would be skipped are rejected as well
+	_, err = h.service.Create(h.ctx, &taskAPI.CreateTaskRequest{
+		ID:     "rejected",
+		Bundle: testutil.NewBundle(t, &specs.Spec{Process: testProcess("true"), Annotations: strict}),
+		Rootfs: []*types.Mount{{Type: "overlay", Source: "overlay"}},
+	})
+	require.True(t, errdefs.IsNotImplemented(errgrpc.ToNative(err)), err)
+	require.ErrorContains(t, err, `rootfs[0].type: mount type "overlay" is not supported`)
+
+	// And are created with a warning in permissive mode
+	h.create("permissive", &specs.Spec{Process: testProcess("sleep", "60"), Hostname: "permissive"})
+
+	h.create("strict", &specs.Spec{Process: testProcess("sleep", "60"), Annotations: strict})
+	h.start("strict", "")
+
+	// Exec processes are validated in the mode of their container
+	process := testProcess("true")
+	process.NoNewPrivileges = true
+	spec, err := typeurl.MarshalAnyToProto(process)
+	require.NoError(t, err)
+
+	_, err = h.service.Exec(h.ctx, &taskAPI.ExecProcessRequest{ID: "strict", ExecID: "exec", Spec: spec})
+	require.True(t, errdefs.IsNotImplemented(errgrpc.ToNative(err)), err)
+	require.ErrorContains(t, err, "process.noNewPrivileges")
+
+	h.kill("strict", "", syscall.SIGKILL)
+	h.wait("strict", "")
+	h.delete("strict", "")
+	h.delete("permissive", "")
+}
@@ -0,0 +1,200 @@
+package integration
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	taskAPI "github.com/containerd/containerd/api/runtime/task/v3"
+	"github.com/containerd/ttrpc"
+	"github.com/darwin-containers/rund/internal/testutil"
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/opencontainers/runtime-spec/specs-go/features"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+)
+
+// runRund runs the rund CLI with state in root and returns its stdout.
+// Output is captured in files rather than pipes, since monitors started by create inherit stdio and outlive rund.
+func runRund(t *testing.T, root string, stdout *os.File, args ...string) (string, error) {
+	if stdout == nil {
+		f, err := os.CreateTemp(t.TempDir(), "stdout")
+		require.NoError(t, err)
+		defer f.Close()
+		stdout = f
+	}
+
+	stderr, err := os.CreateTemp(t.TempDir(), "stderr")
+	require.NoError(t, err)
+	defer stderr.Close()
+
+	cmd := exec.Command(rundBinary, append([]string{"--root", root}, args...)...)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	if err = cmd.Run(); err != nil {
+		message, _ := os.ReadFile(stderr.Name())
+		t.Logf("rund %v: %v: %s", args, err, message)
+	}
+
+	output, readErr := os.ReadFile(stdout.Name())
+	require.NoError(t, readErr)
+
+	return string(output), err
+}
+
+// createContainer creates container id with the rund CLI, container output is written into the returned file.
+// It returns the pid of the container monitor, that is killed if the test fails.
+func createContainer(t *testing.T, root, id, bundle string, args ...string) (*os.File, int) {
+	output, err := os.Create(filepath.Join(t.TempDir(), "output"))
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = output.Close()
+	})
+
+	_, err = runRund(t, root, output, append([]string{"create", "--bundle", bundle}, append(args, id)...)...)
+	require.NoError(t, err)
+
+	conn, err := net.Dial("unix", filepath.Join(root, id, "rund.sock"))
+	require.NoError(t, err)
+
+	client := ttrpc.NewClient(conn)
+	defer client.Close()
+
+	resp, err := taskAPI.NewTTRPCTaskClient(client).Connect(context.Background(), &taskAPI.ConnectRequest{ID: id})
+	require.NoError(t, err)
+
+	pid := int(resp.ShimPid)
+	t.Cleanup(func() {
+		if t.Failed() {
+			_ = unix.Kill(pid, unix.SIGKILL)
+		}
+	})
+
+	return output, pid
+}
+
+func requireState(t *testing.T, root, id string, status specs.ContainerState) specs.State {
+	var state specs.State
+	require.Eventually(t, func() bool {
+		output, err := runRund(t, root, nil, "state", id)
+		require.NoError(t, err)
+		require.NoError(t, json.Unmarshal([]byte(output), &state))
+
+		return state.Status == status
+	}, 5*time.Second, 50*time.Millisecond)
+
+	return state
+}
+
+func TestCLILifecycle(t *testing.T) {
+	root := t.TempDir()
+	bundle := testutil.NewBundle(t, &specs.Spec{
+		Process: &specs.Process{
+			Args: []string{"sh", "-c", "echo hello; exec sleep 60"},
+			Env:  []string{"PATH=/usr/bin:/bin", "FOO=container"},
+			Cwd:  "/",
+		},
+		Annotations: map[string]string{"org.example.test": "value"},
+	})
+	pidFile := filepath.Join(t.TempDir(), "pid")
+
+	output, monitor := createContainer(t, root, "lifecycle", bundle, "--pid-file", pidFile)
+
+	state := requireState(t, root, "lifecycle", specs.StateCreated)
+	require.Equal(t, specs.Version, state.Version)
+	require.Equal(t, "lifecycle", state.ID)
+	require.Equal(t, bundle, state.Bundle)
+	require.Zero(t, state.Pid)
+	require.Equal(t, "value", state.Annotations["org.example.test"])
+
+	_, err := runRund(t, root, nil, "start", "lifecycle")
+	require.NoError(t, err)
+
+	state = requireState(t, root, "lifecycle", specs.StateRunning)
+	require.NotZero(t, state.Pid)
+
+	pid, err := os.ReadFile(pidFile)
+	require.NoError(t, err)
+	require.Equal(t, strconv.Itoa(state.Pid), string(pid))
+
+	require.Eventually(t, func() bool {
+		data, err := os.ReadFile(output.Name())
+		require.NoError(t, err)
+		return string(data) == "hello\n"
+	}, 5*time.Second, 50*time.Millisecond)
+
+	// Exec processes inherit environment of the container process and exit rund with their status
+	stdout, err := runRund(t, root, nil, "exec", "--env", "BAR=exec", "lifecycle", "sh", "-c", "echo $FOO $BAR; exit 3")
+	var exitErr *exec.ExitError
+	require.ErrorAs(t, err, &exitErr)
+	require.Equal(t, 3, exitErr.ExitCode())
+	require.Equal(t, "container exec\n", stdout)
+
+	_, err = runRund(t, root, nil, "delete", "lifecycle")
+	require.Error(t, err, "running container is not deleted without --force")
+
+	_, err = runRund(t, root, nil, "kill", "lifecycle", "KILL")
+	require.NoError(t, err)
+
+	requireState(t, root, "lifecycle", specs.StateStopped)
+
+	_, err = runRund(t, root, nil, "delete", "lifecycle")
+	require.NoError(t, err)
+
+	waitShim(t, monitor)
+
+	_, err = runRund(t, root, nil, "state", "lifecycle")
+	require.Error(t, err)
+
+	_, err = os.Stat(filepath.Join(root, "lifecycle"))
+	require.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func TestCLIDeleteForce(t *testing.T) {
+	root := t.TempDir()
+	bundle := testutil.NewBundle(t, &specs.Spec{
+		Process: &specs.Process{
+			Args: []string{"sleep", "60"},
+			Env:  []string{"PATH=/usr/bin:/bin"},
+			Cwd:  "/",
+		},
+	})
+
+	_, monitor := createContainer(t, root, "force", bundle)
+
+	_, err := runRund(t, root, nil, "start", "force")
+	require.NoError(t, err)
+
+	_, err = runRund(t, root, nil, "delete", "--force", "force")
+	require.NoError(t, err)
+
+	waitShim(t, monitor)
+}
+
+func TestCLICreateFailure(t *testing.T) {
+	root := t.TempDir()
+
+	_, err := runRund(t, root, nil, "create", "--bundle", t.TempDir(), "missing")
+	require.Error(t, err)
+
+	// State of containers that failed to be created is removed
+	_, err = os.Stat(filepath.Join(root, "missing"))
+	require.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func TestCLIFeatures(t *testing.T) {
+	output, err := runRund(t, t.TempDir(), nil, "features")
+	require.NoError(t, err)
+
+	var f features.Features
+	require.NoError(t, json.Unmarshal([]byte(output), &f))
+	require.Equal(t, specs.Version, f.OCIVersionMax)
+	require.Contains(t, f.MountOptions, "rbind")
+	require.Equal(t, "bind,devfs", f.Annotations["io.rund.mount.types"])
+}
@@ -0,0 +1,3 @@
+// Package integration contains tests that run rund as a real shim binary and talk to it over ttrpc,
+// the way containerd does.
+package integration
@@ -0,0 +1,500 @@
+package integration
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	taskAPI "github.com/containerd/containerd/api/runtime/task/v3"
+	eventsAPI "github.com/containerd/containerd/api/services/ttrpc/events/v1"
+	"github.com/containerd/containerd/api/types/task"
+	// Registers OCI spec types for typeurl
+	_ "github.com/containerd/containerd/v2/core/runtime"
+	"github.com/containerd/containerd/v2/pkg/namespaces"
+	"github.com/containerd/containerd/v2/pkg/shim"
+	"github.com/containerd/otelttrpc"
+	"github.com/containerd/ttrpc"
+	"github.com/containerd/typeurl/v2"
+	"github.com/darwin-containers/rund/internal/testutil"
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/sys/unix"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+const namespace = "rund-test"
+
+var (
+	// shimBinary is the path to the shim built by TestMain
+	shimBinary string
+	// rundBinary is the path to the OCI runtime CLI built by TestMain
+	rundBinary string
+)
+
+func TestMain(m *testing.M) {
+	os.Exit(run(m))
+}
+
+func run(m *testing.M) int {
+	if os.Geteuid() != 0 {
+		fmt.Println("skipping shim integration tests, they require root")
+		return 0
+	}
+
+	// Shim daemons and container monitors are orphaned by the start and create actions, become their subreaper to wait for them
+	if err := unix.Prctl(unix.PR_SET_CHILD_SUBREAPER, 1, 0, 0, 0); err != nil {
+		fmt.Println(err)
+		return 1
+	}
+
+	dir, err := os.MkdirTemp("", "rund-integration")
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	defer os.RemoveAll(dir)
+
+	shimBinary = filepath.Join(dir, "containerd-shim-rund-v1")
+	rundBinary = filepath.Join(dir, "rund")
+
+	for binary, pkg := range map[string]string{
+		shimBinary: "../cmd/containerd-shim-rund-v1.go",
+		rundBinary: "../cmd/rund",
+	} {
+		build := exec.Command("go", "build", "-o", binary, pkg)
+		build.Stdout = os.Stdout
+		build.Stderr = os.Stderr
+		if err := build.Run(); err != nil {
+			fmt.Println(err)
+			return 1
+		}
+	}
+
+	return m.Run()
+}
+
+// eventsStub is a stub of containerd ttrpc events service that records topics of forwarded events.
+type eventsStub struct {
+	mu     sync.Mutex
+	topics []string
+}
+
+func (s *eventsStub) Forward(_ context.Context, request *eventsAPI.ForwardRequest) (*emptypb.Empty, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.topics = append(s.topics, request.Envelope.Topic)
+	return &emptypb.Empty{}, nil
+}
+
+func (s *eventsStub) recorded() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]string(nil), s.topics...)
+}
+
+// containerdStub serves containerd ttrpc API used by shims.
+type containerdStub struct {
+	address      string
+	ttrpcAddress string
+	events       *eventsStub
+}
+
+func newContainerdStub(t *testing.T) *containerdStub {
+	dir := t.TempDir()
+
+	stub := &containerdStub{
+		address:      filepath.Join(dir, "containerd.sock"),
+		ttrpcAddress: filepath.Join(dir, "containerd.sock.ttrpc"),
+		events:       &eventsStub{},
+	}
+
+	server, err := ttrpc.NewServer()
+	require.NoError(t, err)
+	eventsAPI.RegisterEventsService(server, stub.events)
+
+	l, err := net.Listen("unix", stub.ttrpcAddress)
+	require.NoError(t, err)
+
+	go func() {
+		_ = server.Serve(context.Background(), l)
+	}()
+	t.Cleanup(func() {
+		_ = server.Close()
+	})
+
+	return stub
+}
+
+func (s *containerdStub) requireEvents(t *testing.T, expected ...string) {
+	require.Eventually(t, func() bool {
+		return len(s.events.recorded()) >= len(expected)
+	}, 5*time.Second, 10*time.Millisecond)
+
+	require.Equal(t, expected, s.events.recorded())
+}
+
+// testShim is a shim started by the start action.
+type testShim struct {
+	task taskAPI.TTRPCTaskService
+	pid  int
+}
+
+// startShim runs the start action of the shim binary in the bundle directory, the way containerd does.
+// env is added to the environment the shim inherits.
+func startShim(t *testing.T, stub *containerdStub, id, bundle string, env ...string) shim.BootstrapParams {
+	return startShimWithFlags(t, stub, id, bundle, nil, env...)
+}
+
+// startShimWithFlags is startShim that passes additional global flags to the shim, such as -debug.
+func startShimWithFlags(t *testing.T, stub *containerdStub, id, bundle string, flags []string, env ...string) shim.BootstrapParams {
+	args := append([]string{"-namespace", namespace, "-id", id, "-address", stub.address}, flags...)
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(shimBinary, append(args, "start")...)
+	cmd.Dir = bundle
+	cmd.Env = append(append(os.Environ(), "TTRPC_ADDRESS="+stub.ttrpcAddress), env...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	require.NoError(t, cmd.Run(), stderr.String())
+
+	var params shim.BootstrapParams
+	require.NoError(t, json.Unmarshal(stdout.Bytes(), &params))
+
+	return params
+}
+
+func connectShim(t *testing.T, params shim.BootstrapParams) *testShim {
+	conn, err := net.Dial("unix", strings.TrimPrefix(params.Address, "unix://"))
+	require.NoError(t, err)
+
+	client := ttrpc.NewClient(conn, ttrpc.WithUnaryClientInterceptor(otelttrpc.UnaryClientInterceptor()))
+	t.Cleanup(func() {
+		_ = client.Close()
+	})
+
+	s := &testShim{
+		task: taskAPI.NewTTRPCTaskClient(client),
+	}
+
+	resp, err := s.task.Connect(context.Background(), &taskAPI.ConnectRequest{})
+	require.NoError(t, err)
+	s.pid = int(resp.ShimPid)
+
+	t.Cleanup(func() {
+		// Shim is left running if the test fails before shutdown
+		if t.Failed() {
+			_ = unix.Kill(s.pid, unix.SIGKILL)
+		}
+	})
+
+	return s
+}
+
+// shutdown asks the shim to shut down and waits until it exits.
+func (s *testShim) shutdown(t *testing.T) {
+	_, err := s.task.Shutdown(context.Background(), &taskAPI.ShutdownRequest{})
+	if err != nil && !errors.Is(err, ttrpc.ErrClosed) {
+		require.NoError(t, err)
+	}
+
+	waitShim(t, s.pid)
+}
+
+func waitShim(t *testing.T, pid int) {
+	done := make(chan error, 1)
+	go func() {
+		_, err := unix.Wait4(pid, nil, 0, nil)
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		require.NoError(t, err)
+	case <-time.After(10 * time.Second):
+		t.Fatalf("shim %d did not exit", pid)
+	}
+}
+
+// lockedBuffer is a buffer that is safe for concurrent use
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.buf.String()
+}
+
+// newBundle creates a bundle, and captures logs that shims started in it write into the log fifo.
+// Logs are printed if the test fails.
+func newBundle(t *testing.T, args ...string) string {
+	bundle := testutil.NewBundle(t, &specs.Spec{
+		Process: &specs.Process{
+			Args: args,
+			Env:  []string{"PATH=/usr/bin:/bin"},
+			Cwd:  "/",
+		},
+	})
+
+	logPath := filepath.Join(bundle, "log")
+	require.NoError(t, unix.Mkfifo(logPath, 0o600))
+
+	// Opening for both reading and writing doesn't block, and keeps fifo open for restarted shims
+	f, err := os.OpenFile(logPath, os.O_RDWR, 0)
+	require.NoError(t, err)
+
+	var logs lockedBuffer
+	go func() {
+		_, _ = io.Copy(&logs, f)
+	}()
+
+	t.Cleanup(func() {
+		_ = f.Close()
+
+		if t.Failed() {
+			t.Logf("shim logs:\n%s", logs.String())
+		}
+	})
+
+	return bundle
+}
+
+func TestShimLifecycle(t *testing.T) {
+	stub := newContainerdStub(t)
+	bundle := newBundle(t, "sh", "-c", "exit 7")
+
+	params := startShim(t, stub, "lifecycle", bundle)
+	require.Equal(t, 3, params.Version)
+	require.Equal(t, "ttrpc", params.Protocol)
+	require.True(t, strings.HasPrefix(params.Address, "unix://"))
+
+	s := connectShim(t, params)
+	ctx := context.Background()
+
+	_, err := s.task.Create(ctx, &taskAPI.CreateTaskRequest{ID: "lifecycle", Bundle: bundle})
+	require.NoError(t, err)
+
+	state, err := s.task.State(ctx, &taskAPI.StateRequest{ID: "lifecycle"})
+	require.NoError(t, err)
+	require.Equal(t, task.Status_CREATED, state.Status)
+
+	started, err := s.task.Start(ctx, &taskAPI.StartRequest{ID: "lifecycle"})
+	require.NoError(t, err)
+	require.NotZero(t, started.Pid)
+
+	waited, err := s.task.Wait(ctx, &taskAPI.WaitRequest{ID: "lifecycle"})
+	require.NoError(t, err)
+	require.Equal(t, uint32(7), waited.ExitStatus)
+
+	deleted, err := s.task.Delete(ctx, &taskAPI.DeleteRequest{ID: "lifecycle"})
+	require.NoError(t, err)
+	require.Equal(t, uint32(7), deleted.ExitStatus)
+	require.Equal(t, started.Pid, deleted.Pid)
+
+	stub.requireEvents(t, "/tasks/create", "/tasks/start", "/tasks/exit", "/tasks/delete")
+
+	s.shutdown(t)
+
+	// Shim removes its socket on shutdown
+	_, err = os.Stat(strings.TrimPrefix(params.Address, "unix://"))
+	require.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func TestShimExecAndKill(t *testing.T) {
+	stub := newContainerdStub(t)
+	bundle := newBundle(t, "sleep", "60")
+
+	s := connectShim(t, startShim(t, stub, "exec", bundle))
+	ctx := context.Background()
+
+	_, err := s.task.Create(ctx, &taskAPI.CreateTaskRequest{ID: "exec", Bundle: bundle})
+	require.NoError(t, err)
+
+	_, err = s.task.Start(ctx, &taskAPI.StartRequest{ID: "exec"})
+	require.NoError(t, err)
+
+	spec, err := typeurl.MarshalAnyToProto(&specs.Process{
+		Args: []string{"sh", "-c", "exit 3"},
+		Env:  []string{"PATH=/usr/bin:/bin"},
+		Cwd:  "/",
+	})
+	require.NoError(t, err)
+
+	_, err = s.task.Exec(ctx, &taskAPI.ExecProcessRequest{
+		ID:     "exec",
+		ExecID: "sh",
+		Spec:   spec,
+	})
+	require.NoError(t, err)
+
+	_, err = s.task.Start(ctx, &taskAPI.StartRequest{ID: "exec", ExecID: "sh"})
+	require.NoError(t, err)
+
+	waited, err := s.task.Wait(ctx, &taskAPI.WaitRequest{ID: "exec", ExecID: "sh"})
+	require.NoError(t, err)
+	require.Equal(t, uint32(3), waited.ExitStatus)
+
+	_, err = s.task.Delete(ctx, &taskAPI.DeleteRequest{ID: "exec", ExecID: "sh"})
+	require.NoError(t, err)
+
+	// Shim doesn't shut down while it has containers
+	_, err = s.task.Shutdown(ctx, &taskAPI.ShutdownRequest{})
+	require.NoError(t, err)
+
+	_, err = s.task.Kill(ctx, &taskAPI.KillRequest{ID: "exec", Signal: uint32(syscall.SIGKILL)})
+	require.NoError(t, err)
+
+	waited, err = s.task.Wait(ctx, &taskAPI.WaitRequest{ID: "exec"})
+	require.NoError(t, err)
+	require.Equal(t, uint32(128+syscall.SIGKILL), waited.ExitStatus)
+
+	_, err = s.task.Delete(ctx, &taskAPI.DeleteRequest{ID: "exec"})
+	require.NoError(t, err)
+
+	stub.requireEvents(t,
+		"/tasks/create",
+		"/tasks/start",
+		"/tasks/exec-added",
+		"/tasks/exec-started",
+		"/tasks/exit",
+		"/tasks/exit",
+		"/tasks/delete",
+	)
+
+	s.shutdown(t)
+}
+
+func TestShimSocketReuse(t *testing.T) {
+	stub := newContainerdStub(t)
+	bundle := newBundle(t, "true")
+
+	params := startShim(t, stub, "reuse", bundle)
+	s := connectShim(t, params)
+
+	// Start of a running shim returns its address without starting another shim
+	again := startShim(t, stub, "reuse", bundle)
+	require.Equal(t, params, again)
+	require.Equal(t, s.pid, connectShim(t, again).pid)
+
+	// Socket left behind by a killed shim is replaced
+	killed := s.pid
+	require.NoError(t, unix.Kill(killed, unix.SIGKILL))
+	waitShim(t, killed)
+
+	replaced := startShim(t, stub, "reuse", bundle)
+	require.Equal(t, params.Address, replaced.Address)
+
+	s = connectShim(t, replaced)
+	require.NotEqual(t, killed, s.pid)
+
+	s.shutdown(t)
+}
+
+func TestShimTracePropagation(t *testing.T) {
+	stub := newContainerdStub(t)
+	bundle := newBundle(t, "true")
+	traces := filepath.Join(t.TempDir(), "traces.json")
+
+	s := connectShim(t, startShim(t, stub, "traced", bundle, "RUND_TRACES_FILE="+traces))
+
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	traceID := trace.TraceID{0x72, 0x75, 0x6e, 0x64, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12}
+	ctx := trace.ContextWithSpanContext(context.Background(), trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    traceID,
+		SpanID:     trace.SpanID{1, 2, 3, 4, 5, 6, 7, 8},
+		TraceFlags: trace.FlagsSampled,
+	}))
+
+	_, err := s.task.Create(ctx, &taskAPI.CreateTaskRequest{ID: "traced", Bundle: bundle})
+	require.NoError(t, err)
+
+	_, err = s.task.Delete(ctx, &taskAPI.DeleteRequest{ID: "traced"})
+	require.NoError(t, err)
+
+	// Spans are flushed on shutdown
+	s.shutdown(t)
+
+	content, err := os.ReadFile(traces)
+	require.NoError(t, err)
+	require.Contains(t, string(content), `"Name":"rund.Create"`)
+	require.Contains(t, string(content), `"Name":"rund.mount.All"`)
+	require.Contains(t, string(content), `"TraceID":"`+traceID.String()+`"`)
+}
+
+func TestShimDebugSocket(t *testing.T) {
+	stub := newContainerdStub(t)
+	bundle := newBundle(t, "sleep", "60")
+
+	s := connectShim(t, startShimWithFlags(t, stub, "debug", bundle, []string{"-debug"}))
+	ctx := context.Background()
+
+	_, err := s.task.Create(ctx, &taskAPI.CreateTaskRequest{ID: "debug", Bundle: bundle})
+	require.NoError(t, err)
+
+	debugAddress, err := shim.SocketAddress(namespaces.WithNamespace(ctx, namespace), stub.address, "debug", true)
+	require.NoError(t, err)
+
+	client := &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var dialer net.Dialer
+				return dialer.DialContext(ctx, "unix", strings.TrimPrefix(debugAddress, "unix://"))
+			},
+		},
+	}
+	t.Cleanup(client.CloseIdleConnections)
+
+	get := func(path string) string {
+		resp, err := client.Get("http://shim" + path)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+
+		return string(body)
+	}
+
+	require.Contains(t, get("/metrics"), "rund_containers 1\n")
+	require.Contains(t, get("/debug/containers"), `"id": "debug"`)
+	require.Contains(t, get("/debug/pprof/"), "goroutine")
+
+	_, err = s.task.Delete(ctx, &taskAPI.DeleteRequest{ID: "debug"})
+	require.NoError(t, err)
+
+	s.shutdown(t)
+
+	// Shim removes its debug socket on shutdown
+	_, err = os.Stat(strings.TrimPrefix(debugAddress, "unix://"))
+	require.ErrorIs(t, err, os.ErrNotExist)
+}
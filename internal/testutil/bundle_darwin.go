@@ -0,0 +1,13 @@
+package testutil
+
+import (
+	"testing"
+
+	"github.com/opencontainers/runtime-spec/specs-go"
+)
+
+// NewBundle is not implemented on Darwin yet, as rootfs would need bindfs mounts of host binaries.
+func NewBundle(t testing.TB, _ *specs.Spec) string {
+	t.Skip("requires Linux")
+	return ""
+}
@@ -0,0 +1,61 @@
+// Package testutil provides helpers shared by rund tests.
+package testutil
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/containerd/containerd/v2/core/mount"
+	"github.com/containerd/containerd/v2/pkg/oci"
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+)
+
+// NewBundle creates a bundle with spec and a rootfs that shares host binaries and libraries using read-only bind mounts.
+// Root path of the spec is set to the rootfs. Rootfs is unmounted when the test finishes.
+func NewBundle(t testing.TB, spec *specs.Spec) string {
+	if os.Geteuid() != 0 {
+		t.Skip("requires root")
+	}
+
+	bundle := t.TempDir()
+	rootfs := filepath.Join(bundle, "rootfs")
+	require.NoError(t, os.Mkdir(rootfs, 0o755))
+
+	t.Cleanup(func() {
+		require.NoError(t, mount.UnmountRecursive(rootfs, unix.MNT_DETACH))
+	})
+
+	for _, dir := range []string{"bin", "lib", "lib64", "sbin", "usr"} {
+		source := filepath.Join("/", dir)
+		target := filepath.Join(rootfs, dir)
+
+		stat, err := os.Lstat(source)
+		if os.IsNotExist(err) {
+			continue
+		}
+		require.NoError(t, err)
+
+		if stat.Mode()&os.ModeSymlink != 0 {
+			link, err := os.Readlink(source)
+			require.NoError(t, err)
+			require.NoError(t, os.Symlink(link, target))
+			continue
+		}
+
+		require.NoError(t, os.Mkdir(target, 0o755))
+		require.NoError(t, unix.Mount(source, target, "", unix.MS_BIND|unix.MS_REC, ""))
+		require.NoError(t, unix.Mount("", target, "", unix.MS_BIND|unix.MS_REMOUNT|unix.MS_RDONLY, ""))
+	}
+
+	spec.Root = &specs.Root{Path: rootfs}
+
+	config, err := json.Marshal(spec)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(bundle, oci.ConfigFilename), config, 0o644))
+
+	return bundle
+}